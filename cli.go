@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"nyct-feed/internal/gtfs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const formatUsage = "output format: text, json or csv"
+
+// runDepartures prints the upcoming departures from a station.
+func runDepartures(args []string) {
+	flags := flag.NewFlagSet("departures", flag.ExitOnError)
+	station := flags.String("station", "", "station name or stop ID, e.g. \"14 St-Union Sq\" or L03 (required)")
+	routes := flags.String("route", "", "comma separated route IDs to include (default all)")
+	direction := flags.String("direction", "", "direction to include, N or S (default both)")
+	limit := flags.Int("limit", 3, "departures per route and direction, 0 for all")
+	format := flags.String("format", "text", formatUsage)
+	offline := flags.Bool("offline", false, "use the cached schedule and recorded realtime feeds")
+	schedulePath := flags.String("schedule", "", "local GTFS schedule ZIP or directory to read instead of fetching it")
+	feeds := flags.String("feeds", "", feedsUsage+` (default "nyct", or recorded feeds when offline)`)
+	flags.Parse(args)
+
+	if *station == "" {
+		log.Fatalln("Missing required flag: --station")
+	}
+	validateFormat(*format)
+	filter := parseFilter(*routes, *direction)
+
+	schedule := loadSchedule(*offline, *schedulePath)
+	stations := findStations(schedule.GetStations(), *station)
+	if len(stations) == 0 {
+		log.Fatalf("No station matches %q, see the stations subcommand", *station)
+	}
+
+	realtime, err := gtfs.NewRealtimePoller(feedSources(*feeds, *offline)).Poll(context.Background())
+	if err != nil {
+		log.Printf("Error fetching realtime feeds, showing scheduled departures: %v", err)
+		realtime = &gtfs.Realtime{}
+	}
+	for _, feed := range realtime.DegradedFeeds() {
+		log.Printf("Feed %s is degraded: %v", feed.Name, feed.Err)
+	}
+
+	now := time.Now()
+	if *offline && len(realtime.Messages()) > 0 {
+		now = gtfs.FeedTimestamp(realtime.Messages())
+	}
+
+	rows := []departureRow{}
+	for _, station := range stations {
+		stopIds := []string{station.StopId + "N", station.StopId + "S"}
+		for _, departure := range gtfs.FindDepartures(stopIds, realtime.Messages(), schedule, now) {
+			if !filter.Matches(departure) {
+				continue
+			}
+			rows = append(rows, newDepartureRows(station, departure, now, *limit)...)
+		}
+	}
+	slices.SortStableFunc(rows, func(a, b departureRow) int {
+		return a.Departs.Compare(b.Departs)
+	})
+
+	writeRows(os.Stdout, *format, rows)
+}
+
+// runStations prints the stations in the schedule.
+func runStations(args []string) {
+	flags := flag.NewFlagSet("stations", flag.ExitOnError)
+	searchText := flags.String("search", "", "only include stations whose name contains this text")
+	near := flags.String("near", "", "sort stations by distance from latitude,longitude, e.g. 40.73,-73.99")
+	limit := flags.Int("limit", 0, "maximum number of stations, 0 for all")
+	format := flags.String("format", "text", formatUsage)
+	offline := flags.Bool("offline", false, "use the cached schedule")
+	schedulePath := flags.String("schedule", "", "local GTFS schedule ZIP or directory to read instead of fetching it")
+	flags.Parse(args)
+	validateFormat(*format)
+
+	schedule := loadSchedule(*offline, *schedulePath)
+	search := strings.ToLower(*searchText)
+	isMatch := func(station gtfs.Station) bool {
+		return strings.Contains(strings.ToLower(station.StopName), search)
+	}
+
+	if *near != "" {
+		coordinates, err := gtfs.ParseCoordinates(*near)
+		if err != nil {
+			log.Fatalln("Error parsing --near:", err)
+		}
+		// Searching filters the nearby stations, so the index can only be limited without a search
+		nearbyLimit := *limit
+		if search != "" {
+			nearbyLimit = 0
+		}
+		rows := []nearbyStationRow{}
+		for _, station := range schedule.FindNearbyStations(coordinates, nearbyLimit) {
+			if isMatch(station.Station) && (*limit <= 0 || len(rows) < *limit) {
+				rows = append(rows, nearbyStationRow{
+					stationRow:  newStationRow(station.Station),
+					DistanceM:   int(math.Round(station.Distance)),
+					WalkMinutes: int(math.Ceil(gtfs.WalkingTime(station.Distance).Minutes())),
+				})
+			}
+		}
+		writeRows(os.Stdout, *format, rows)
+		return
+	}
+
+	rows := []stationRow{}
+	for _, station := range schedule.GetStations() {
+		if isMatch(station) && (*limit <= 0 || len(rows) < *limit) {
+			rows = append(rows, newStationRow(station))
+		}
+	}
+	writeRows(os.Stdout, *format, rows)
+}
+
+// runRoutes prints the routes in the schedule.
+func runRoutes(args []string) {
+	flags := flag.NewFlagSet("routes", flag.ExitOnError)
+	format := flags.String("format", "text", formatUsage)
+	offline := flags.Bool("offline", false, "use the cached schedule")
+	schedulePath := flags.String("schedule", "", "local GTFS schedule ZIP or directory to read instead of fetching it")
+	flags.Parse(args)
+	validateFormat(*format)
+
+	schedule := loadSchedule(*offline, *schedulePath)
+	rows := []routeRow{}
+	for _, route := range schedule.Routes {
+		rows = append(rows, routeRow{
+			RouteId:   route.RouteId,
+			ShortName: route.RouteShortName,
+			LongName:  route.RouteLongName,
+			Color:     route.RouteColor,
+		})
+	}
+
+	writeRows(os.Stdout, *format, rows)
+}
+
+// loadSchedule reads the schedule from schedulePath or the cache when offline, and fetches it otherwise.
+func loadSchedule(offline bool, schedulePath string) *gtfs.Schedule {
+	var schedule *gtfs.Schedule
+	var err error
+	if offline || schedulePath != "" {
+		schedule, err = gtfs.GetLocalSchedule(schedulePath)
+	} else {
+		schedule, err = gtfs.GetSchedule(context.Background())
+	}
+	if err != nil {
+		log.Fatalln("Error loading schedule:", err)
+	}
+	return schedule
+}
+
+// parseFilter parses the --route and --direction flags.
+func parseFilter(routes string, direction string) gtfs.DepartureFilter {
+	filter := gtfs.DepartureFilter{Direction: strings.ToUpper(direction)}
+	if filter.Direction != "" && filter.Direction != "N" && filter.Direction != "S" {
+		log.Fatalf("Unknown direction %q, expected N or S", direction)
+	}
+	for _, routeId := range strings.Split(routes, ",") {
+		if routeId = strings.TrimSpace(routeId); routeId != "" {
+			filter.RouteIds = append(filter.RouteIds, routeId)
+		}
+	}
+	return filter
+}
+
+// findStations returns the stations whose stop ID or name (ignoring case) is query.
+// Several stations may share a name, e.g. the platforms of a station complex.
+func findStations(stations []gtfs.Station, query string) []gtfs.Station {
+	matches := []gtfs.Station{}
+	for _, station := range stations {
+		if station.StopId == query || strings.EqualFold(station.StopName, query) {
+			matches = append(matches, station)
+		}
+	}
+	return matches
+}
+
+type departureRow struct {
+	StationId    string    `json:"station_id"`
+	StationName  string    `json:"station_name"`
+	RouteId      string    `json:"route_id"`
+	Direction    string    `json:"direction"`
+	Destination  string    `json:"destination"`
+	Departs      time.Time `json:"departs"`
+	Minutes      int       `json:"minutes"`
+	DelaySeconds int       `json:"delay_seconds"`
+	Source       string    `json:"source"`
+	Track        string    `json:"track,omitempty"`
+	TrainId      string    `json:"train_id,omitempty"`
+	TripId       string    `json:"trip_id"`
+}
+
+// newDepartureRows returns a row for each of the (at most limit) departure times that have not yet passed.
+func newDepartureRows(station gtfs.Station, departure gtfs.Departure, now time.Time, limit int) []departureRow {
+	rows := []departureRow{}
+	for _, departureTime := range departure.Times {
+		if limit > 0 && len(rows) == limit {
+			break
+		}
+		minutes := math.Round(departureTime.Time().Sub(now).Minutes())
+		if minutes < 0 {
+			continue
+		}
+		rows = append(rows, departureRow{
+			StationId:    station.StopId,
+			StationName:  station.StopName,
+			RouteId:      departure.RouteId,
+			Direction:    departure.StopId[len(departure.StopId)-1:],
+			Destination:  departure.FinalStopName,
+			Departs:      departureTime.Time(),
+			Minutes:      int(minutes),
+			DelaySeconds: int(departureTime.Delay.Seconds()),
+			Source:       departureTime.Source.String(),
+			Track:        departureTime.Track.String(),
+			TrainId:      departureTime.TrainId,
+			TripId:       departureTime.TripId,
+		})
+	}
+	return rows
+}
+
+func (departureRow) header() []string {
+	return []string{"station_id", "station_name", "route_id", "direction", "destination", "departs", "minutes", "delay_seconds", "source", "track", "train_id", "trip_id"}
+}
+
+func (r departureRow) record() []string {
+	return []string{r.StationId, r.StationName, r.RouteId, r.Direction, r.Destination, r.Departs.Format(time.RFC3339),
+		strconv.Itoa(r.Minutes), strconv.Itoa(r.DelaySeconds), r.Source, r.Track, r.TrainId, r.TripId}
+}
+
+func (departureRow) textHeader() []string {
+	return []string{"ROUTE", "DIR", "DESTINATION", "DEPARTS", "IN", "DELAY", "TRACK", "SOURCE"}
+}
+
+func (r departureRow) text() []string {
+	in := "Now"
+	if r.Minutes > 0 {
+		in = fmt.Sprintf("%d min", r.Minutes)
+	}
+	delay := ""
+	if minutes := math.Round(float64(r.DelaySeconds) / 60); minutes != 0 {
+		delay = fmt.Sprintf("%+v", minutes)
+	}
+	departs := r.Departs.In(gtfs.Location).Format("3:04 PM")
+	return []string{r.RouteId, r.Direction, r.Destination, departs, in, delay, r.Track, r.Source}
+}
+
+type stationRow struct {
+	StationId string   `json:"station_id"`
+	Name      string   `json:"name"`
+	Routes    []string `json:"routes"`
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+}
+
+func newStationRow(station gtfs.Station) stationRow {
+	routeIds := []string{}
+	for _, route := range station.Routes {
+		routeIds = append(routeIds, route.RouteId)
+	}
+	return stationRow{
+		StationId: station.StopId,
+		Name:      station.StopName,
+		Routes:    routeIds,
+		Lat:       station.StopLat,
+		Lon:       station.StopLon,
+	}
+}
+
+func (stationRow) header() []string {
+	return []string{"station_id", "name", "routes", "lat", "lon"}
+}
+
+func (r stationRow) record() []string {
+	return []string{r.StationId, r.Name, strings.Join(r.Routes, " "),
+		strconv.FormatFloat(r.Lat, 'f', -1, 64), strconv.FormatFloat(r.Lon, 'f', -1, 64)}
+}
+
+func (stationRow) textHeader() []string {
+	return []string{"ID", "NAME", "ROUTES"}
+}
+
+func (r stationRow) text() []string {
+	return []string{r.StationId, r.Name, strings.Join(r.Routes, " ")}
+}
+
+// nearbyStationRow is a station with its distance from the point searched from.
+type nearbyStationRow struct {
+	stationRow
+	DistanceM   int `json:"distance_m"`
+	WalkMinutes int `json:"walk_minutes"`
+}
+
+func (r nearbyStationRow) header() []string {
+	return append(r.stationRow.header(), "distance_m", "walk_minutes")
+}
+
+func (r nearbyStationRow) record() []string {
+	return append(r.stationRow.record(), strconv.Itoa(r.DistanceM), strconv.Itoa(r.WalkMinutes))
+}
+
+func (r nearbyStationRow) textHeader() []string {
+	return append(r.stationRow.textHeader(), "DISTANCE", "WALK")
+}
+
+func (r nearbyStationRow) text() []string {
+	return append(r.stationRow.text(), fmt.Sprintf("%d m", r.DistanceM), fmt.Sprintf("%d min", r.WalkMinutes))
+}
+
+type routeRow struct {
+	RouteId   string `json:"route_id"`
+	ShortName string `json:"short_name"`
+	LongName  string `json:"long_name"`
+	Color     string `json:"color"`
+}
+
+func (routeRow) header() []string {
+	return []string{"route_id", "short_name", "long_name", "color"}
+}
+
+func (r routeRow) record() []string {
+	return []string{r.RouteId, r.ShortName, r.LongName, r.Color}
+}
+
+func (routeRow) textHeader() []string {
+	return []string{"ID", "NAME", "DESCRIPTION"}
+}
+
+func (r routeRow) text() []string {
+	return []string{r.RouteId, r.ShortName, r.LongName}
+}
+
+// row is a line of subcommand output in each of the supported formats.
+type row interface {
+	header() []string     // CSV column names
+	record() []string     // CSV fields
+	textHeader() []string // Text column headings
+	text() []string       // Text columns
+}
+
+func validateFormat(format string) {
+	if format != "text" && format != "json" && format != "csv" {
+		log.Fatalf("Unknown format %q, expected text, json or csv", format)
+	}
+}
+
+// writeRows writes rows to w as aligned text columns, a JSON array or CSV with a header.
+func writeRows[T row](w io.Writer, format string, rows []T) {
+	var err error
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(rows)
+	case "csv":
+		var zero T
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write(zero.header())
+		for _, r := range rows {
+			csvWriter.Write(r.record())
+		}
+		csvWriter.Flush()
+		err = csvWriter.Error()
+	default:
+		var zero T
+		tabWriter := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tabWriter, strings.Join(zero.textHeader(), "\t"))
+		for _, r := range rows {
+			fmt.Fprintln(tabWriter, strings.Join(r.text(), "\t"))
+		}
+		err = tabWriter.Flush()
+	}
+	if err != nil {
+		log.Fatalln("Error writing output:", err)
+	}
+}
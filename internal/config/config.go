@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"nyct-feed/internal/gtfs"
+)
+
+const (
+	appDir     = "nyct-feed"
+	configFile = "config.json"
+	dirPerms   = 0755
+	filePerms  = 0644
+)
+
+// Config is the user's preferences, persisted across sessions.
+type Config struct {
+	Favorites     []string `json:"favorites"`       // IDs of favorite stations in the order they were added
+	LastStationId string   `json:"last_station_id"` // ID of the station viewed most recently
+	// Departure filters by station ID. Stations without a filter show every departure.
+	Filters map[string]gtfs.DepartureFilter `json:"filters,omitempty"`
+	path    string
+}
+
+// Load reads the config from the user's config directory, e.g. ~/.config/nyct-feed/config.json.
+// An empty config is returned if the file does not exist yet.
+func Load() (*Config, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find config directory: %v", err)
+	}
+	return LoadFile(filepath.Join(configDir, appDir, configFile))
+}
+
+// LoadFile reads the config from path. An empty config is returned if the file does not exist yet.
+func LoadFile(path string) (*Config, error) {
+	c := &Config{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the config back to the file it was loaded from.
+func (c *Config) Save() error {
+	if c.path == "" {
+		return nil // Not backed by a file
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), dirPerms); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+
+	// Write to a temporary file first so that an interrupted write cannot corrupt the config
+	tmpPath := c.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, filePerms); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	return nil
+}
+
+func (c *Config) IsFavorite(stationId string) bool {
+	return slices.Contains(c.Favorites, stationId)
+}
+
+// ToggleFavorite adds the station to the favorites, or removes it if it is already a favorite.
+func (c *Config) ToggleFavorite(stationId string) {
+	if i := slices.Index(c.Favorites, stationId); i != -1 {
+		c.Favorites = slices.Delete(c.Favorites, i, i+1)
+		return
+	}
+	c.Favorites = append(c.Favorites, stationId)
+}
+
+// Filter returns the departure filter of the station, which is zero if it has none.
+func (c *Config) Filter(stationId string) gtfs.DepartureFilter {
+	return c.Filters[stationId]
+}
+
+// SetFilter sets the departure filter of the station, removing it if the filter is zero.
+func (c *Config) SetFilter(stationId string, filter gtfs.DepartureFilter) {
+	if filter.IsZero() {
+		delete(c.Filters, stationId)
+		return
+	}
+	if c.Filters == nil {
+		c.Filters = map[string]gtfs.DepartureFilter{}
+	}
+	c.Filters[stationId] = filter
+}
@@ -2,18 +2,49 @@ package csvutil
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-// ReadAllParsed is [csv.Reader.ReadAll] except it parses each row into the given record struct.
-// Each property in record should specify a csv tag denoting its column header, otherwise it will be ignored.
-func ReadAllParsed[Record any](r io.Reader, record Record) ([]Record, error) {
-	recordType := reflect.TypeOf(record)
-	out := []Record{}
+// Decoder reads CSV rows one at a time and parses each into a record struct.
+// Each property in Record should specify a csv tag denoting its column header, otherwise it will be ignored.
+type Decoder[Record any] struct {
+	// ReuseRecord reuses the row buffer of the underlying [csv.Reader] between calls to Decode.
+	// Parsed string fields remain valid since they never refer to the buffer.
+	ReuseRecord bool
+
+	reader *csv.Reader
+	plan   []fieldPlan
+}
+
+// fieldPlan is how a single field of the record struct is set from a row.
+type fieldPlan struct {
+	index int // Field index in the record struct
+	col   int // Column index in the row
+	name  string
+	kind  reflect.Kind
+}
+
+// planKey identifies a cached plan: the record type and the header row it was planned for.
+type planKey struct {
+	recordType reflect.Type
+	headers    string // Header row joined by NUL
+}
+
+// plans caches the plan of every record type and header row seen, which is never modified once built.
+var plans sync.Map // planKey -> []fieldPlan
+
+// NewDecoder reads the header row from r and plans which column sets each field of Record,
+// so that rows are decoded without looking up struct tags again.
+// Plans are cached, so decoding another file with the same record type and header row does not plan again.
+func NewDecoder[Record any](r io.Reader) (*Decoder[Record], error) {
+	recordType := reflect.TypeFor[Record]()
 
 	// Only accept structs
 	if recordType.Kind() != reflect.Struct {
@@ -21,75 +52,148 @@ func ReadAllParsed[Record any](r io.Reader, record Record) ([]Record, error) {
 	}
 
 	csvReader := csv.NewReader(r)
-	records, err := csvReader.ReadAll()
+	headers, err := csvReader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("CSV must not have length of 0")
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read all of CSV: %v", err)
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
 	}
-	if len(records) == 0 {
-		return nil, fmt.Errorf("CSV must not have length of 0")
+
+	key := planKey{recordType, strings.Join(headers, "\x00")}
+	if plan, exists := plans.Load(key); exists {
+		return &Decoder[Record]{reader: csvReader, plan: plan.([]fieldPlan)}, nil
+	}
+	plan, err := newPlan(recordType, headers)
+	if err != nil {
+		return nil, err
 	}
+	plans.Store(key, plan)
+	return &Decoder[Record]{reader: csvReader, plan: plan}, nil
+}
 
+// newPlan plans which column of headers sets each field of recordType.
+func newPlan(recordType reflect.Type, headers []string) ([]fieldPlan, error) {
 	// Map header to column number
-	headers := records[0]
 	headerToCol := make(map[string]int)
 	for i, header := range headers {
 		headerToCol[header] = i
 	}
 
-	// Create new records
-	for _, record := range records[1:] {
-		newRecord := reflect.New(recordType).Elem()
+	plan := []fieldPlan{}
+	for i := 0; i < recordType.NumField(); i++ {
+		fieldType := recordType.Field(i)
 
-		for i := 0; i < recordType.NumField(); i++ {
-			fieldValue := newRecord.Field(i)
-			fieldType := newRecord.Type().Field(i)
+		// Get CSV header from tag or skip
+		header := fieldType.Tag.Get("csv")
+		if header == "" {
+			continue
+		}
+		col, exists := headerToCol[header]
+		if !exists {
+			continue
+		}
 
-			// Get CSV header from tag or skip
-			header := fieldType.Tag.Get("csv")
-			if header == "" {
-				continue
-			}
+		switch kind := fieldType.Type.Kind(); kind {
+		case reflect.String, reflect.Int, reflect.Int64, reflect.Bool, reflect.Float64:
+			plan = append(plan, fieldPlan{index: i, col: col, name: fieldType.Name, kind: kind})
+		default:
+			return nil, fmt.Errorf("unsupported type %v for field %s", kind, fieldType.Name)
+		}
+	}
+	return plan, nil
+}
 
-			// Parse and set field
-			if col, exists := headerToCol[header]; exists && col < len(record) {
-				field := record[col]
-
-				switch fieldType.Type.Kind() {
-				case reflect.String:
-					val := strings.Trim(field, "\"")
-					fieldValue.SetString(val)
-				case reflect.Int:
-					val, err := strconv.Atoi(field)
-					if err != nil && field != "" {
-						return nil, fmt.Errorf("failed to parse field %s: %v", fieldType.Name, err)
-					}
-					fieldValue.SetInt(int64(val))
-				case reflect.Int64:
-					val, err := strconv.ParseInt(field, 10, 64)
-					if err != nil && field != "" {
-						return nil, fmt.Errorf("failed to parse field %s: %v", fieldType.Name, err)
-					}
-					fieldValue.SetInt(val)
-				case reflect.Bool:
-					val, err := strconv.ParseBool(field)
-					if err != nil {
-						return nil, fmt.Errorf("failed to parse field %s: %v", fieldType.Name, err)
-					}
-					fieldValue.SetBool(val)
-				case reflect.Float64:
-					val, err := strconv.ParseFloat(field, 64)
-					if err != nil {
-						return nil, fmt.Errorf("failed to parse field %s: %v", fieldType.Name, err)
-					}
-					fieldValue.SetFloat(val)
-				default:
-					return nil, fmt.Errorf("unsupported type %v for field %s", fieldType.Type.Kind(), fieldType.Name)
-				}
+// Decode parses the next row into record, overwriting the fields that have a column.
+// io.EOF is returned once every row has been read.
+func (d *Decoder[Record]) Decode(record *Record) error {
+	d.reader.ReuseRecord = d.ReuseRecord
+	row, err := d.reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return fmt.Errorf("failed to read CSV row: %v", err)
+	}
+
+	recordValue := reflect.ValueOf(record).Elem()
+	for _, field := range d.plan {
+		if field.col >= len(row) {
+			continue
+		}
+		if err := setField(recordValue.Field(field.index), field, row[field.col]); err != nil {
+			line, _ := d.reader.FieldPos(field.col)
+			return fmt.Errorf("failed to parse field %s on line %d: %v", field.name, line, err)
+		}
+	}
+	return nil
+}
+
+// All returns an iterator over the remaining rows, stopping after the first error.
+// Every row is decoded into the same record, which is yielded by value.
+func (d *Decoder[Record]) All() iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		var record Record
+		for {
+			err := d.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if !yield(record, err) || err != nil {
+				return
 			}
 		}
+	}
+}
+
+func setField(fieldValue reflect.Value, field fieldPlan, value string) error {
+	switch field.kind {
+	case reflect.String:
+		fieldValue.SetString(strings.Trim(value, "\""))
+	case reflect.Int:
+		val, err := strconv.Atoi(value)
+		if err != nil && value != "" {
+			return err
+		}
+		fieldValue.SetInt(int64(val))
+	case reflect.Int64:
+		val, err := strconv.ParseInt(value, 10, 64)
+		if err != nil && value != "" {
+			return err
+		}
+		fieldValue.SetInt(val)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fieldValue.SetBool(val)
+	case reflect.Float64:
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		fieldValue.SetFloat(val)
+	}
+	return nil
+}
 
-		out = append(out, newRecord.Interface().(Record))
+// ReadAllParsed is [csv.Reader.ReadAll] except it parses each row into the given record struct.
+// Rows are decoded as they are read with a [Decoder], so the raw CSV is never held in memory.
+// The value of record is unused, it only determines the type.
+func ReadAllParsed[Record any](r io.Reader, record Record) ([]Record, error) {
+	decoder, err := NewDecoder[Record](r)
+	if err != nil {
+		return nil, err
 	}
+	decoder.ReuseRecord = true
 
+	out := []Record{}
+	for row, err := range decoder.All() {
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, row)
+	}
 	return out, nil
 }
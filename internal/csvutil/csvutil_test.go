@@ -0,0 +1,134 @@
+package csvutil
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testRecord struct {
+	Id      string  `csv:"id"`
+	Count   int     `csv:"count"`
+	Active  bool    `csv:"active"`
+	Lat     float64 `csv:"lat"`
+	Ignored string
+}
+
+const testCSV = `count,id,lat,active,extra
+1,"a",40.5,1,x
+,b,-73.25,0,y
+`
+
+func TestDecode(t *testing.T) {
+	decoder, err := NewDecoder[testRecord](strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+
+	want := []testRecord{
+		{Id: "a", Count: 1, Active: true, Lat: 40.5},
+		{Id: "b", Count: 0, Active: false, Lat: -73.25},
+	}
+	for _, want := range want {
+		record := testRecord{Ignored: "kept"}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		want.Ignored = "kept"
+		if record != want {
+			t.Errorf("Decode = %+v, want %+v", record, want)
+		}
+	}
+	if err := decoder.Decode(&testRecord{}); !errors.Is(err, io.EOF) {
+		t.Errorf("Decode after the last row = %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeReportsLine(t *testing.T) {
+	decoder, err := NewDecoder[testRecord](strings.NewReader("id,count\na,1\nb,many\n"))
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	var record testRecord
+	if err := decoder.Decode(&record); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	err = decoder.Decode(&record)
+	if err == nil || !strings.Contains(err.Error(), "field Count on line 3") {
+		t.Errorf("Decode = %v, want an error for Count on line 3", err)
+	}
+}
+
+func TestNewDecoderErrors(t *testing.T) {
+	if _, err := NewDecoder[testRecord](strings.NewReader("")); err == nil {
+		t.Error("NewDecoder of an empty CSV succeeded, want an error")
+	}
+	if _, err := NewDecoder[string](strings.NewReader("id\n")); err == nil {
+		t.Error("NewDecoder of a non-struct record succeeded, want an error")
+	}
+	type unsupported struct {
+		Ids []string `csv:"id"`
+	}
+	if _, err := NewDecoder[unsupported](strings.NewReader("id\n")); err == nil {
+		t.Error("NewDecoder of an unsupported field type succeeded, want an error")
+	}
+}
+
+func TestAll(t *testing.T) {
+	for _, reuseRecord := range []bool{false, true} {
+		decoder, err := NewDecoder[testRecord](strings.NewReader(testCSV))
+		if err != nil {
+			t.Fatalf("NewDecoder: %v", err)
+		}
+		decoder.ReuseRecord = reuseRecord
+
+		// Records yielded earlier must not change as later rows are read
+		records := []testRecord{}
+		for record, err := range decoder.All() {
+			if err != nil {
+				t.Fatalf("All: %v", err)
+			}
+			records = append(records, record)
+		}
+		ids := []string{}
+		for _, record := range records {
+			ids = append(ids, record.Id)
+		}
+		if want := []string{"a", "b"}; !slices.Equal(ids, want) {
+			t.Errorf("ReuseRecord = %v: got IDs %v, want %v", reuseRecord, ids, want)
+		}
+	}
+}
+
+func TestAllStopsAtError(t *testing.T) {
+	decoder, err := NewDecoder[testRecord](strings.NewReader("id,active\na,1\nb,maybe\nc,0\n"))
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	ids, errs := []string{}, 0
+	for record, err := range decoder.All() {
+		if err != nil {
+			errs++
+			continue
+		}
+		ids = append(ids, record.Id)
+	}
+	if !slices.Equal(ids, []string{"a"}) || errs != 1 {
+		t.Errorf("got IDs %v and %d errors, want a and a single error", ids, errs)
+	}
+}
+
+func TestPlanCachedPerHeader(t *testing.T) {
+	// A cached plan must not be used for a header row with columns in another order
+	for _, csv := range []string{"id,count\na,1\n", "count,id\n1,a\n"} {
+		records, err := ReadAllParsed(strings.NewReader(csv), testRecord{})
+		if err != nil {
+			t.Fatalf("ReadAllParsed: %v", err)
+		}
+		if want := (testRecord{Id: "a", Count: 1}); len(records) != 1 || records[0] != want {
+			t.Errorf("ReadAllParsed(%q) = %+v, want %+v", csv, records, want)
+		}
+	}
+}
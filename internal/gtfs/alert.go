@@ -0,0 +1,134 @@
+package gtfs
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"nyct-feed/internal/pb"
+)
+
+type Alert struct {
+	AlertId     string
+	Header      string
+	Description string
+	RouteIds    []string // Routes informed by the alert
+	StopIds     []string // Stops informed by the alert
+}
+
+// FindAlerts returns the alerts active at now that inform any of the given stops, or any of
+// the given routes without being limited to other stops. Stop IDs match their platforms,
+// e.g. "635" matches "635N".
+func FindAlerts(stopIds []string, routeIds []string, realtime []*pb.FeedMessage, now time.Time) []Alert {
+	alerts := []Alert{}
+	for _, feedMsg := range realtime {
+		for _, feedEntity := range feedMsg.GetEntity() {
+			alert := feedEntity.GetAlert()
+			if alert == nil || !isAlertActive(alert, now) {
+				continue
+			}
+
+			matched := false
+			for _, selector := range alert.GetInformedEntity() {
+				if isSelectorMatch(selector, stopIds, routeIds) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+
+			alerts = append(alerts, newAlert(feedEntity.GetId(), alert))
+		}
+	}
+
+	slices.SortFunc(alerts, func(a, b Alert) int {
+		return strings.Compare(a.Header, b.Header)
+	})
+
+	return alerts
+}
+
+func newAlert(alertId string, alert *pb.Alert) Alert {
+	routeIds := []string{}
+	stopIds := []string{}
+	for _, selector := range alert.GetInformedEntity() {
+		routeId := selector.GetRouteId()
+		if routeId == "" {
+			routeId = selector.GetTrip().GetRouteId()
+		}
+		if routeId != "" && !slices.Contains(routeIds, routeId) {
+			routeIds = append(routeIds, routeId)
+		}
+		if stopId := selector.GetStopId(); stopId != "" && !slices.Contains(stopIds, stopId) {
+			stopIds = append(stopIds, stopId)
+		}
+	}
+
+	return Alert{
+		AlertId:     alertId,
+		Header:      translate(alert.GetHeaderText()),
+		Description: translate(alert.GetDescriptionText()),
+		RouteIds:    routeIds,
+		StopIds:     stopIds,
+	}
+}
+
+// isAlertActive reports whether now falls within any of the alert's active periods.
+// Alerts without active periods are always active.
+func isAlertActive(alert *pb.Alert, now time.Time) bool {
+	periods := alert.GetActivePeriod()
+	if len(periods) == 0 {
+		return true
+	}
+
+	unix := uint64(now.Unix())
+	for _, period := range periods {
+		afterStart := period.GetStart() == 0 || period.GetStart() <= unix
+		beforeEnd := period.GetEnd() == 0 || unix < period.GetEnd()
+		if afterStart && beforeEnd {
+			return true
+		}
+	}
+	return false
+}
+
+// isSelectorMatch reports whether every route and stop specified by the selector is among
+// the given routes and stops. Selectors that specify neither never match.
+func isSelectorMatch(selector *pb.EntitySelector, stopIds []string, routeIds []string) bool {
+	routeId := selector.GetRouteId()
+	if routeId == "" {
+		routeId = selector.GetTrip().GetRouteId()
+	}
+	stopId := selector.GetStopId()
+	if routeId == "" && stopId == "" {
+		return false
+	}
+
+	if routeId != "" && !slices.Contains(routeIds, routeId) {
+		return false
+	}
+	if stopId != "" && !slices.ContainsFunc(stopIds, func(id string) bool {
+		return strings.HasPrefix(stopId, id) || strings.HasPrefix(id, stopId)
+	}) {
+		return false
+	}
+	return true
+}
+
+// translate returns the plain English text of a translated string,
+// falling back to the first translation available.
+func translate(translated *pb.TranslatedString) string {
+	translations := translated.GetTranslation()
+	for _, translation := range translations {
+		language := translation.GetLanguage()
+		if language == "en" || language == "" {
+			return translation.GetText()
+		}
+	}
+	if len(translations) > 0 {
+		return translations[0].GetText()
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package gtfs
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/pb"
+)
+
+func testTranslatedString(text string) *pb.TranslatedString {
+	return &pb.TranslatedString{Translation: []*pb.TranslatedString_Translation{{Text: proto.String(text), Language: proto.String("en")}}}
+}
+
+func testAlertEntity(id string, header string, selectors ...*pb.EntitySelector) *pb.FeedEntity {
+	return &pb.FeedEntity{
+		Id: proto.String(id),
+		Alert: &pb.Alert{
+			InformedEntity: selectors,
+			HeaderText:     testTranslatedString(header),
+		},
+	}
+}
+
+func TestFindAlerts(t *testing.T) {
+	now := time.Unix(1000, 0)
+	expired := testAlertEntity("expired", "Expired", &pb.EntitySelector{RouteId: proto.String("L")})
+	expired.Alert.ActivePeriod = []*pb.TimeRange{{Start: proto.Uint64(100), End: proto.Uint64(200)}}
+	msg := &pb.FeedMessage{Entity: []*pb.FeedEntity{
+		testAlertEntity("route", "L trains delayed", &pb.EntitySelector{RouteId: proto.String("L")}),
+		testAlertEntity("platform", "Elevator out", &pb.EntitySelector{StopId: proto.String("L03N")}),
+		testAlertEntity("trip", "Bypassing stop", &pb.EntitySelector{Trip: &pb.TripDescriptor{RouteId: proto.String("L")}}),
+		// Limited to a stop of another station
+		testAlertEntity("elsewhere", "Elsewhere", &pb.EntitySelector{RouteId: proto.String("L"), StopId: proto.String("L08")}),
+		testAlertEntity("other route", "A trains delayed", &pb.EntitySelector{RouteId: proto.String("A")}),
+		testAlertEntity("empty", "No selector", &pb.EntitySelector{}),
+		expired,
+		{Id: proto.String("trip update"), TripUpdate: &pb.TripUpdate{Trip: &pb.TripDescriptor{RouteId: proto.String("L")}}},
+	}}
+
+	alerts := FindAlerts([]string{"L03"}, []string{"L"}, []*pb.FeedMessage{msg}, now)
+	ids := []string{}
+	for _, alert := range alerts {
+		ids = append(ids, alert.AlertId)
+	}
+	// Sorted by header
+	if want := []string{"trip", "platform", "route"}; !slices.Equal(ids, want) {
+		t.Errorf("got alerts %v, want %v", ids, want)
+	}
+	if len(alerts) == 3 && (!slices.Equal(alerts[1].StopIds, []string{"L03N"}) || !slices.Equal(alerts[0].RouteIds, []string{"L"})) {
+		t.Errorf("got stops %v and routes %v, want L03N and L", alerts[1].StopIds, alerts[0].RouteIds)
+	}
+}
+
+func TestIsAlertActive(t *testing.T) {
+	tests := []struct {
+		name    string
+		periods []*pb.TimeRange
+		want    bool
+	}{
+		{"no periods", nil, true},
+		{"within", []*pb.TimeRange{{Start: proto.Uint64(900), End: proto.Uint64(1100)}}, true},
+		{"at start", []*pb.TimeRange{{Start: proto.Uint64(1000), End: proto.Uint64(1100)}}, true},
+		{"at end", []*pb.TimeRange{{Start: proto.Uint64(900), End: proto.Uint64(1000)}}, false},
+		{"not started", []*pb.TimeRange{{Start: proto.Uint64(1100)}}, false},
+		{"open start", []*pb.TimeRange{{End: proto.Uint64(1100)}}, true},
+		{"open end", []*pb.TimeRange{{Start: proto.Uint64(900)}}, true},
+		{"later period", []*pb.TimeRange{{Start: proto.Uint64(100), End: proto.Uint64(200)}, {Start: proto.Uint64(900)}}, true},
+	}
+	for _, test := range tests {
+		alert := &pb.Alert{ActivePeriod: test.periods}
+		if got := isAlertActive(alert, time.Unix(1000, 0)); got != test.want {
+			t.Errorf("%s: isAlertActive = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	translation := func(text, language string) *pb.TranslatedString_Translation {
+		tr := &pb.TranslatedString_Translation{Text: proto.String(text)}
+		if language != "" {
+			tr.Language = proto.String(language)
+		}
+		return tr
+	}
+	tests := []struct {
+		name         string
+		translations []*pb.TranslatedString_Translation
+		want         string
+	}{
+		{"english", []*pb.TranslatedString_Translation{translation("<p>Delays</p>", "en-html"), translation("Delays", "en")}, "Delays"},
+		{"unspecified language", []*pb.TranslatedString_Translation{translation("Retrasos", "es"), translation("Delays", "")}, "Delays"},
+		{"fallback", []*pb.TranslatedString_Translation{translation("Retrasos", "es")}, "Retrasos"},
+		{"none", nil, ""},
+	}
+	for _, test := range tests {
+		if got := translate(&pb.TranslatedString{Translation: test.translations}); got != test.want {
+			t.Errorf("%s: translate = %q, want %q", test.name, got, test.want)
+		}
+	}
+	if got := translate(nil); got != "" {
+		t.Errorf("translate(nil) = %q, want empty", got)
+	}
+}
+
+func TestAlertsOnly(t *testing.T) {
+	alerts := &pb.FeedMessage{Entity: []*pb.FeedEntity{testAlertEntity("1", "Delays")}}
+	trips := &pb.FeedMessage{Entity: []*pb.FeedEntity{
+		testAlertEntity("1", "Delays"),
+		{Id: proto.String("2"), TripUpdate: &pb.TripUpdate{Trip: &pb.TripDescriptor{RouteId: proto.String("L")}}},
+	}}
+	tests := []struct {
+		feed FeedState
+		want bool
+	}{
+		{FeedState{Name: "subway-alerts"}, true},
+		{FeedState{Name: "gtfs-l"}, false},
+		{FeedState{Name: "unknown"}, false},
+		{FeedState{Name: "unknown", Msg: alerts}, true},
+		{FeedState{Name: "unknown", Msg: trips}, false},
+	}
+	for _, test := range tests {
+		if got := test.feed.AlertsOnly(); got != test.want {
+			t.Errorf("AlertsOnly of %s with %d entities = %v, want %v", test.feed.Name, len(test.feed.Msg.GetEntity()), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package gtfs
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/pb"
+)
+
+const segmentExt = ".pb.gz"
+
+// headerFieldNumber is the field number of FeedMessage.header
+const headerFieldNumber = 1
+
+// Archive appends raw feed messages to compressed segment files, one directory per feed.
+// Each segment is named after the header timestamp of its first message and holds
+// length-delimited messages. Every message is written as its own gzip member,
+// so a segment remains readable even if the recorder is interrupted mid-write.
+type Archive struct {
+	dir             string
+	maxSegmentBytes int64
+	feeds           map[string]*archivedFeed
+	mu              sync.Mutex
+}
+
+// archivedFeed tracks the segment currently being appended to for a single feed.
+type archivedFeed struct {
+	segmentPath   string
+	lastTimestamp uint64
+}
+
+// NewArchive returns an archive rooted at dir that starts a new segment
+// once the current one reaches maxSegmentBytes. An empty dir archives to the
+// recorded feeds directory read by [RecordedFeedSources].
+func NewArchive(dir string, maxSegmentBytes int64) *Archive {
+	if dir == "" {
+		dir = feedsDir
+	}
+	return &Archive{
+		dir:             dir,
+		maxSegmentBytes: maxSegmentBytes,
+		feeds:           map[string]*archivedFeed{},
+	}
+}
+
+// Append archives a snapshot under feedName as raw protobuf, keyed by its header timestamp.
+// The bytes are stored as fetched; a message without them is encoded first.
+// Snapshots that are not newer than the last archived snapshot of the feed are skipped,
+// which deduplicates identical snapshots. Reports whether the snapshot was archived.
+func (a *Archive) Append(feedName string, snapshot Snapshot) (bool, error) {
+	timestamp := snapshot.Msg.GetHeader().GetTimestamp()
+	data := snapshot.Data
+	if data == nil {
+		var err error
+		data, err = proto.Marshal(snapshot.Msg)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode feed message: %v", err)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	feed, err := a.loadFeed(feedName)
+	if err != nil {
+		return false, err
+	}
+	if timestamp <= feed.lastTimestamp {
+		return false, nil
+	}
+
+	// Rotate to a new segment once the current one is full
+	if feed.segmentPath == "" || fileSize(feed.segmentPath) >= a.maxSegmentBytes {
+		feed.segmentPath = filepath.Join(a.dir, feedName, fmt.Sprintf("%d%s", timestamp, segmentExt))
+	}
+
+	if err := appendSegment(feed.segmentPath, data); err != nil {
+		return false, fmt.Errorf("failed to append to segment %s: %v", feed.segmentPath, err)
+	}
+	feed.lastTimestamp = timestamp
+	return true, nil
+}
+
+// loadFeed returns the archive state of feedName, resuming from its newest segment on first use.
+func (a *Archive) loadFeed(feedName string) (*archivedFeed, error) {
+	if feed, exists := a.feeds[feedName]; exists {
+		return feed, nil
+	}
+
+	feedDir := filepath.Join(a.dir, feedName)
+	if err := os.MkdirAll(feedDir, dirPerms); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory %s: %v", feedDir, err)
+	}
+
+	feed := &archivedFeed{}
+	segments, err := listSegments(feedDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		feed.segmentPath = segments[len(segments)-1]
+		last, validSize, err := readLastSegmentMessage(feed.segmentPath)
+		if err != nil {
+			return nil, err
+		}
+		feed.lastTimestamp = last.Msg.GetHeader().GetTimestamp()
+
+		// Cut off a member left incomplete by an interrupted write, so new members are not appended behind it
+		if fileSize(feed.segmentPath) > validSize {
+			if err := os.Truncate(feed.segmentPath, validSize); err != nil {
+				return nil, fmt.Errorf("failed to truncate segment %s: %v", feed.segmentPath, err)
+			}
+		}
+	}
+
+	a.feeds[feedName] = feed
+	return feed, nil
+}
+
+// ReadArchive calls fn with every message archived in dir, feed by feed in timestamp order.
+// An empty dir reads the recorded feeds directory. Iteration stops at the first error returned by fn.
+func ReadArchive(dir string, fn func(feedName string, msg *pb.FeedMessage) error) error {
+	return ReadArchiveWindow(dir, time.Time{}, time.Time{}, func(feedName string, timestamp time.Time, data []byte) error {
+		msg := &pb.FeedMessage{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return fmt.Errorf("failed to decode message of %s at %v: %v", feedName, timestamp.Unix(), err)
+		}
+		return fn(feedName, msg)
+	})
+}
+
+// ReadArchiveWindow calls fn with the raw bytes and header timestamp of every message archived in dir
+// between from and to inclusive, feed by feed in timestamp order. A zero from or to leaves that end
+// of the window unbounded. Segments outside the window are skipped by their names, and only the header
+// of every message is decoded. An empty dir reads the recorded feeds directory.
+// Iteration stops at the first error returned by fn.
+func ReadArchiveWindow(dir string, from, to time.Time, fn func(feedName string, timestamp time.Time, data []byte) error) error {
+	if dir == "" {
+		dir = feedsDir
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read archive directory %s: %v", dir, err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		err := readFeedArchive(filepath.Join(dir, entry.Name()), from, to, func(timestamp time.Time, data []byte) error {
+			return fn(entry.Name(), timestamp, data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFeedArchive calls fn with every message archived in feedDir between from and to in timestamp order.
+func readFeedArchive(feedDir string, from, to time.Time, fn func(timestamp time.Time, data []byte) error) error {
+	segments, err := listSegments(feedDir)
+	if err != nil {
+		return err
+	}
+
+	for i, segmentPath := range segments {
+		// Timestamps only increase, so every message of a segment comes before the next segment starts
+		if !to.IsZero() && segmentStart(segmentPath).After(to) {
+			break
+		}
+		if !from.IsZero() && i+1 < len(segments) && !segmentStart(segments[i+1]).After(from) {
+			continue
+		}
+
+		_, err := readSegment(segmentPath, func(data []byte) error {
+			header, err := readMessageHeader(data)
+			if err != nil {
+				return fmt.Errorf("failed to decode message in %s: %v", segmentPath, err)
+			}
+			timestamp := time.Unix(int64(header.GetTimestamp()), 0)
+			if (!from.IsZero() && timestamp.Before(from)) || (!to.IsZero() && timestamp.After(to)) {
+				return nil
+			}
+			return fn(timestamp, data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentStart returns the header timestamp of the first message of a segment, which it is named after.
+func segmentStart(segmentPath string) time.Time {
+	timestamp, _ := strconv.ParseUint(strings.TrimSuffix(filepath.Base(segmentPath), segmentExt), 10, 64)
+	return time.Unix(int64(timestamp), 0)
+}
+
+// readMessageHeader decodes only the header of an encoded feed message, skipping its entities.
+func readMessageHeader(data []byte) (*pb.FeedHeader, error) {
+	header := &pb.FeedHeader{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if num == headerFieldNumber && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			// Repeated occurrences of a message field are merged, as proto.Unmarshal does
+			if err := (proto.UnmarshalOptions{Merge: true, AllowPartial: true}).Unmarshal(value, header); err != nil {
+				return nil, err
+			}
+			data = data[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return header, nil
+}
+
+// listSegments returns the paths of all segments in feedDir sorted by timestamp.
+func listSegments(feedDir string) ([]string, error) {
+	entries, err := os.ReadDir(feedDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive directory %s: %v", feedDir, err)
+	}
+
+	type segment struct {
+		path      string
+		timestamp uint64
+	}
+	segments := []segment{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		timestamp, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue // Not a segment
+		}
+		segments = append(segments, segment{filepath.Join(feedDir, name), timestamp})
+	}
+
+	slices.SortFunc(segments, func(a, b segment) int {
+		return cmp.Compare(a.timestamp, b.timestamp)
+	})
+
+	paths := make([]string, len(segments))
+	for i, segment := range segments {
+		paths[i] = segment.path
+	}
+	return paths, nil
+}
+
+// appendSegment writes data to the end of the segment as a new length-delimited gzip member.
+func appendSegment(segmentPath string, data []byte) error {
+	f, err := os.OpenFile(segmentPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerms)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	if err == nil {
+		_, err = gz.Write(data)
+	}
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readSegment calls fn with the raw bytes of every message in the segment and returns the size of
+// the segment up to the end of its last complete gzip member. Reading stops at a truncated or corrupt
+// member, left behind by an interrupted write, and everything after it is ignored.
+func readSegment(segmentPath string, fn func(data []byte) error) (int64, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// gzip reads exactly up to the end of each member from a byte reader, so r counts the complete members
+	r := &countingReader{r: bufio.NewReader(f)}
+	gz := &gzip.Reader{}
+	var validSize int64
+	for {
+		if err := gz.Reset(r); err != nil {
+			return validSize, nil // io.EOF at the end of the segment, otherwise a truncated header
+		}
+		gz.Multistream(false)
+
+		messages, err := readSegmentMember(gz)
+		if err != nil {
+			return validSize, nil
+		}
+		for _, data := range messages {
+			if err := fn(data); err != nil {
+				return validSize, err
+			}
+		}
+		validSize = r.n
+	}
+}
+
+// readSegmentMember reads the length-delimited messages of a single gzip member,
+// which fails if the member is truncated or its checksum does not match.
+func readSegmentMember(gz *gzip.Reader) ([][]byte, error) {
+	r := bufio.NewReader(gz)
+	messages := [][]byte{}
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		messages = append(messages, data)
+	}
+}
+
+// readLastSegmentMessage decodes the newest message in the segment
+// and returns the size of the segment up to the end of its last complete member.
+func readLastSegmentMessage(segmentPath string) (Snapshot, int64, error) {
+	var last []byte
+	validSize, err := readSegment(segmentPath, func(data []byte) error {
+		last = data
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, 0, err
+	}
+
+	msg := &pb.FeedMessage{}
+	if err := proto.Unmarshal(last, msg); err != nil {
+		return Snapshot{}, 0, fmt.Errorf("failed to decode message in %s: %v", segmentPath, err)
+	}
+	return Snapshot{Msg: msg, Data: last}, validSize, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
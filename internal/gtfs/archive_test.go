@@ -0,0 +1,197 @@
+package gtfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/pb"
+)
+
+func testFeedMessage(timestamp uint64, entities ...*pb.FeedEntity) *pb.FeedMessage {
+	return &pb.FeedMessage{
+		Header: &pb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(timestamp),
+		},
+		Entity: entities,
+	}
+}
+
+func testSnapshot(timestamp uint64) Snapshot {
+	return Snapshot{Msg: testFeedMessage(timestamp)}
+}
+
+func readArchiveTimestamps(t *testing.T, dir string) []uint64 {
+	t.Helper()
+	timestamps := []uint64{}
+	err := ReadArchive(dir, func(feedName string, msg *pb.FeedMessage) error {
+		timestamps = append(timestamps, msg.GetHeader().GetTimestamp())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	return timestamps
+}
+
+func TestArchiveAppendAfterTruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+	archive := NewArchive(dir, 1<<20)
+	for _, timestamp := range []uint64{100, 200} {
+		if _, err := archive.Append("ace", testSnapshot(timestamp)); err != nil {
+			t.Fatalf("Append(%d): %v", timestamp, err)
+		}
+	}
+
+	// Cut the last member short, as a crash mid-write would
+	segments, err := listSegments(filepath.Join(dir, "ace"))
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("listSegments = %v, %v; want a single segment", segments, err)
+	}
+	size := fileSize(segments[0])
+	if err := os.Truncate(segments[0], size-5); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readArchiveTimestamps(t, dir), []uint64{100}; !slices.Equal(got, want) {
+		t.Fatalf("after truncation read %v, want %v", got, want)
+	}
+
+	// A new recorder resumes the segment and appends behind the last complete member
+	archive = NewArchive(dir, 1<<20)
+	archived, err := archive.Append("ace", testSnapshot(200))
+	if err != nil || !archived {
+		t.Fatalf("Append(200) = %v, %v; want true, nil", archived, err)
+	}
+	if _, err := archive.Append("ace", testSnapshot(300)); err != nil {
+		t.Fatalf("Append(300): %v", err)
+	}
+	if got, want := readArchiveTimestamps(t, dir), []uint64{100, 200, 300}; !slices.Equal(got, want) {
+		t.Fatalf("after resuming read %v, want %v", got, want)
+	}
+}
+
+func TestReadSegmentStopsAtCorruptMember(t *testing.T) {
+	dir := t.TempDir()
+	archive := NewArchive(dir, 1<<20)
+	if _, err := archive.Append("ace", testSnapshot(100)); err != nil {
+		t.Fatalf("Append(100): %v", err)
+	}
+	segments, err := listSegments(filepath.Join(dir, "ace"))
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("listSegments = %v, %v; want a single segment", segments, err)
+	}
+	firstMemberSize := fileSize(segments[0])
+	if _, err := archive.Append("ace", testSnapshot(200)); err != nil {
+		t.Fatalf("Append(200): %v", err)
+	}
+
+	// Flip a byte of the second member's checksum
+	data, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-6] ^= 0xff
+	if err := os.WriteFile(segments[0], data, filePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	last, validSize, err := readLastSegmentMessage(segments[0])
+	if err != nil {
+		t.Fatalf("readLastSegmentMessage: %v", err)
+	}
+	if got := last.Msg.GetHeader().GetTimestamp(); got != 100 {
+		t.Errorf("last timestamp = %d, want 100", got)
+	}
+	if validSize != firstMemberSize {
+		t.Errorf("valid size = %d, want %d", validSize, firstMemberSize)
+	}
+}
+
+func TestArchiveStoresFetchedBytes(t *testing.T) {
+	dir := t.TempDir()
+	// Concatenated messages merge when decoded, so re-encoding them would not give back the same bytes
+	data, err := proto.Marshal(testFeedMessage(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := proto.Marshal(testFeedMessage(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, more...)
+	snapshot, err := FileSource{Path: writeTestFile(t, "gtfs-ace.pb", data)}.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	archive := NewArchive(dir, 1<<20)
+	if _, err := archive.Append("ace", snapshot); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	segments, err := listSegments(filepath.Join(dir, "ace"))
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("listSegments = %v, %v; want a single segment", segments, err)
+	}
+	last, _, err := readLastSegmentMessage(segments[0])
+	if err != nil {
+		t.Fatalf("readLastSegmentMessage: %v", err)
+	}
+	if !bytes.Equal(last.Data, data) {
+		t.Errorf("archived %x, want the fetched bytes %x", last.Data, data)
+	}
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, filePerms); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadArchiveWindowSkipsSegments(t *testing.T) {
+	dir := t.TempDir()
+	// A segment per message, and a segment holding two
+	for _, timestamp := range []uint64{100, 200, 300} {
+		if _, err := NewArchive(dir, 1).Append("ace", testSnapshot(timestamp)); err != nil {
+			t.Fatalf("Append(%d): %v", timestamp, err)
+		}
+	}
+	archive := NewArchive(dir, 1<<20)
+	for _, timestamp := range []uint64{400, 500} {
+		if _, err := archive.Append("ace", testSnapshot(timestamp)); err != nil {
+			t.Fatalf("Append(%d): %v", timestamp, err)
+		}
+	}
+	// A segment before the window with a message that cannot be decoded must not be opened
+	if err := appendSegment(filepath.Join(dir, "ace", "50"+segmentExt), []byte{0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamps := []int64{}
+	err := ReadArchiveWindow(dir, time.Unix(200, 0), time.Unix(450, 0), func(feedName string, timestamp time.Time, data []byte) error {
+		msg := &pb.FeedMessage{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if int64(msg.GetHeader().GetTimestamp()) != timestamp.Unix() {
+			t.Errorf("message at %d reported at %v", msg.GetHeader().GetTimestamp(), timestamp.Unix())
+		}
+		timestamps = append(timestamps, timestamp.Unix())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadArchiveWindow: %v", err)
+	}
+	if want := []int64{200, 300, 400}; !slices.Equal(timestamps, want) {
+		t.Errorf("read %v, want %v", timestamps, want)
+	}
+}
@@ -0,0 +1,62 @@
+package gtfs
+
+import (
+	"time"
+)
+
+const (
+	MinPollInterval = 5 * time.Second  // Shortest wait between realtime polls
+	MaxPollInterval = 30 * time.Second // Longest wait between realtime polls while they are due
+	// pollMargin is how long after a feed's expected update it is polled, allowing for the producer's delay in publishing it
+	pollMargin = 2 * time.Second
+)
+
+const (
+	scheduleRefetchInterval = time.Hour
+	// The schedule is not refetched between these hours, New York time, since nobody is waiting on it overnight
+	scheduleQuietStart = 1
+	scheduleQuietEnd   = 5
+)
+
+// PollInterval returns how long to wait before polling the realtime feeds again so that the poll lands
+// just after the next feed is expected to publish an update, based on how often each feed's header timestamp advances.
+// Feeds whose cadence is not known yet or whose update is overdue are polled every MinPollInterval,
+// while feeds that have not been fetched or have stopped updating upstream are ignored.
+func (r *Realtime) PollInterval(now time.Time) time.Duration {
+	if r == nil {
+		return MinPollInterval
+	}
+
+	interval := MaxPollInterval
+	for _, feed := range r.Feeds {
+		if feed.Msg == nil || FreshnessOf(feed.Age(now)) == Dead {
+			continue
+		}
+		if feed.UpdateInterval == 0 {
+			return MinPollInterval
+		}
+		nextUpdate := time.Unix(int64(feed.Msg.GetHeader().GetTimestamp()), 0).Add(feed.UpdateInterval)
+		interval = min(interval, nextUpdate.Sub(now)+pollMargin)
+	}
+	return max(interval, MinPollInterval)
+}
+
+// RefetchInterval returns how long after a fetch at fetchedAt to refetch the schedule.
+// A schedule read from the cache by [NewScheduleFetcher] is revalidated right away, otherwise see [ScheduleRefetchInterval].
+func (s *Schedule) RefetchInterval(fetchedAt time.Time) time.Duration {
+	if s != nil && s.cache.fromCache {
+		return time.Nanosecond // Already due, but a zero interval would stop refetching
+	}
+	return ScheduleRefetchInterval(fetchedAt)
+}
+
+// ScheduleRefetchInterval returns how long after a fetch at fetchedAt to refetch the schedule: hourly, except that
+// a refetch falling overnight is put off until the morning.
+func ScheduleRefetchInterval(fetchedAt time.Time) time.Duration {
+	next := fetchedAt.Add(scheduleRefetchInterval).In(Location)
+	if hour := next.Hour(); hour >= scheduleQuietStart && hour < scheduleQuietEnd {
+		morning := time.Date(next.Year(), next.Month(), next.Day(), scheduleQuietEnd, 0, 0, 0, Location)
+		return morning.Sub(fetchedAt)
+	}
+	return scheduleRefetchInterval
+}
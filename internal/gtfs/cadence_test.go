@@ -0,0 +1,38 @@
+package gtfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleRefetchInterval(t *testing.T) {
+	tests := []struct {
+		fetchedAt time.Time
+		want      time.Duration
+	}{
+		{time.Date(2026, 6, 1, 12, 0, 0, 0, Location), time.Hour},
+		{time.Date(2026, 6, 1, 23, 30, 0, 0, Location), time.Hour},
+		// A refetch falling overnight waits until 05:00
+		{time.Date(2026, 6, 1, 0, 10, 0, 0, Location), 4*time.Hour + 50*time.Minute},
+		{time.Date(2026, 6, 1, 3, 59, 0, 0, Location), time.Hour + time.Minute},
+		{time.Date(2026, 6, 1, 4, 0, 0, 0, Location), time.Hour},
+	}
+	for _, test := range tests {
+		if got := ScheduleRefetchInterval(test.fetchedAt); got != test.want {
+			t.Errorf("ScheduleRefetchInterval(%v) = %v, want %v", test.fetchedAt, got, test.want)
+		}
+	}
+}
+
+func TestCachedScheduleRefetchedRightAway(t *testing.T) {
+	fetchedAt := time.Date(2026, 6, 1, 0, 10, 0, 0, Location)
+	if got := (&Schedule{cache: scheduleCache{fromCache: true}}).RefetchInterval(fetchedAt); got <= 0 || got > time.Second {
+		t.Errorf("RefetchInterval of a cached schedule = %v, want it due now", got)
+	}
+	if got, want := (&Schedule{}).RefetchInterval(fetchedAt), ScheduleRefetchInterval(fetchedAt); got != want {
+		t.Errorf("RefetchInterval = %v, want %v", got, want)
+	}
+	if got, want := (*Schedule)(nil).RefetchInterval(fetchedAt), ScheduleRefetchInterval(fetchedAt); got != want {
+		t.Errorf("RefetchInterval of a schedule that failed to load = %v, want %v", got, want)
+	}
+}
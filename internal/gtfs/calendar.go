@@ -0,0 +1,107 @@
+package gtfs
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Embed time zones for systems without a zoneinfo database
+)
+
+const dateLayout = "20060102"
+
+// Location is the time zone that schedule times are expressed in.
+var Location = mustLoadLocation("America/New_York")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Panicf("failed to load location %s: %v", name, err)
+	}
+	return loc
+}
+
+// ActiveServiceIds returns the IDs of the services running on the service day of date.
+// Services are active if the calendar covers the date and weekday, with calendar date exceptions applied.
+func (s *Schedule) ActiveServiceIds(date time.Time) map[string]struct{} {
+	date = date.In(Location)
+	dateStr := date.Format(dateLayout)
+	weekday := date.Weekday()
+
+	serviceIds := map[string]struct{}{}
+	// Add service ID if date is in range and weekday receives service
+	for _, calendar := range s.Calendars {
+		isDateInService := calendar.StartDate <= dateStr && calendar.EndDate >= dateStr
+		isWeekdayInService := calendar.IsWeekdayActive(weekday)
+
+		if isDateInService && isWeekdayInService {
+			serviceIds[calendar.ServiceId] = struct{}{}
+		}
+	}
+	// Add or remove service IDs based on calendar date exceptions
+	for _, calendarDate := range s.CalendarDates {
+		if calendarDate.Date == dateStr {
+			if calendarDate.ExceptionType == 1 { // Added service
+				serviceIds[calendarDate.ServiceId] = struct{}{}
+			} else if calendarDate.ExceptionType == 2 { // Cancelled service
+				delete(serviceIds, calendarDate.ServiceId)
+			} else {
+				log.Printf("invalid exception type on calendar date: %v", calendarDate)
+			}
+		}
+	}
+
+	return serviceIds
+}
+
+// ServiceDay returns the start of the service day of date in the schedule's time zone.
+// Per the GTFS reference this is noon minus 12h, which differs from midnight on days with DST changes.
+func ServiceDay(date time.Time) time.Time {
+	date = date.In(Location)
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, Location)
+	return noon.Add(-12 * time.Hour)
+}
+
+// ParseTime converts a GTFS "HH:MM:SS" time on the given service day into a time.Time.
+// Hours may exceed 23 for trips that run past midnight into the next day.
+func ParseTime(serviceDay time.Time, gtfsTime string) (time.Time, error) {
+	parts := strings.Split(strings.TrimSpace(gtfsTime), ":")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid GTFS time %q", gtfsTime)
+	}
+
+	var hms [3]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return time.Time{}, fmt.Errorf("invalid GTFS time %q", gtfsTime)
+		}
+		hms[i] = value
+	}
+
+	offset := time.Duration(hms[0])*time.Hour + time.Duration(hms[1])*time.Minute + time.Duration(hms[2])*time.Second
+	return serviceDay.Add(offset), nil
+}
+
+func (c *Calendar) IsWeekdayActive(weekday time.Weekday) bool {
+	switch weekday {
+	case time.Monday:
+		return c.Monday
+	case time.Tuesday:
+		return c.Tuesday
+	case time.Wednesday:
+		return c.Wednesday
+	case time.Thursday:
+		return c.Thursday
+	case time.Friday:
+		return c.Friday
+	case time.Saturday:
+		return c.Saturday
+	case time.Sunday:
+		return c.Sunday
+	default:
+		log.Panicln("unreachable code: invalid weekday")
+		return false
+	}
+}
@@ -2,6 +2,7 @@ package gtfs
 
 const (
 	dataDir   = "data/"
+	feedsDir  = dataDir + "feeds/"
 	dirPerms  = 0755
 	filePerms = 0644
 )
@@ -8,122 +8,258 @@ import (
 	"time"
 )
 
+// scheduleWindow is how far ahead scheduled departures are included for routes without realtime data.
+const scheduleWindow = 2 * time.Hour
+
+type DepartureSource int
+
+const (
+	SourceRealtime  DepartureSource = iota // Predicted by a realtime feed
+	SourceScheduled                        // Scheduled only, the route has no realtime data
+	SourceCancelled                        // Cancelled or skipping the stop per a realtime feed
+)
+
+func (ds DepartureSource) String() string {
+	switch ds {
+	case SourceRealtime:
+		return "Realtime"
+	case SourceScheduled:
+		return "Scheduled"
+	case SourceCancelled:
+		return "Cancelled"
+	default:
+		log.Panicf("Unknown DepartureSource: %d", int(ds))
+		return "Unknown"
+	}
+}
+
 type Departure struct {
 	RouteId       string
 	StopId        string
 	FinalStopId   string
 	FinalStopName string
-	Times         []time.Time
+	Times         []DepartureTime // Sorted by Time
+}
+
+// DepartureTime is a single trip's departure from a stop.
+type DepartureTime struct {
+	TripId     string
+	TrainId    string        // NYCT train ID, e.g. "06 0123+ PEL/BBR", empty if unknown
+	Scheduled  time.Time     // Zero if the trip could not be matched to the schedule
+	Predicted  time.Time     // Zero if the trip has no realtime prediction
+	Delay      time.Duration // Predicted minus scheduled, zero if either is unknown
+	Source     DepartureSource
+	Unassigned bool  // The trip has not been assigned a train yet and may not run
+	Track      Track // Zero if the feed does not provide tracks
 }
 
-func FindDepartures(stopIds []string, realtime []*pb.FeedMessage, schedule *Schedule) []Departure {
-	tripToTimes := map[[3]string][]time.Time{}
-	for _, stopId := range stopIds {
-		for _, feedMsg := range realtime {
-			for _, feedEntity := range feedMsg.GetEntity() {
-				tripUpdate := feedEntity.GetTripUpdate()
-				routeId := tripUpdate.GetTrip().GetRouteId()
-				stopTimes := tripUpdate.GetStopTimeUpdate()
+// Time returns the predicted departure time, or the scheduled time if there is no prediction.
+func (dt DepartureTime) Time() time.Time {
+	if !dt.Predicted.IsZero() {
+		return dt.Predicted
+	}
+	return dt.Scheduled
+}
+
+// DepartureFilter limits departures to certain routes and directions. The zero value matches every departure.
+type DepartureFilter struct {
+	RouteIds  []string `json:"route_ids,omitempty"` // Routes to include, empty for every route
+	Direction string   `json:"direction,omitempty"` // "N" or "S" to include a single direction, empty for both
+}
+
+// IsZero reports whether the filter matches every departure.
+func (f DepartureFilter) IsZero() bool {
+	return len(f.RouteIds) == 0 && f.Direction == ""
+}
+
+// MatchesRoute reports whether departures of the route are included.
+func (f DepartureFilter) MatchesRoute(routeId string) bool {
+	return len(f.RouteIds) == 0 || slices.Contains(f.RouteIds, routeId)
+}
+
+// Matches reports whether the departure is included. The direction is the suffix of its stop ID.
+func (f DepartureFilter) Matches(departure Departure) bool {
+	return f.MatchesRoute(departure.RouteId) && strings.HasSuffix(departure.StopId, f.Direction)
+}
+
+// FindDepartures returns the departures from the given stops, joining realtime trip updates with
+// their scheduled trips to compute delays. Routes without any realtime data fall back to
+// scheduled departures in the window after now.
+func FindDepartures(stopIds []string, realtime []*pb.FeedMessage, schedule *Schedule, now time.Time) []Departure {
+	tripToTimes := map[[3]string][]DepartureTime{}
+	realtimeRouteIds := map[string]struct{}{}
+	for _, feedMsg := range realtime {
+		for _, feedEntity := range feedMsg.GetEntity() {
+			tripUpdate := feedEntity.GetTripUpdate()
+			if tripUpdate == nil {
+				continue
+			}
+			trip := tripUpdate.GetTrip()
+			routeId := trip.GetRouteId()
+			realtimeRouteIds[routeId] = struct{}{}
+
+			stopTimes := tripUpdate.GetStopTimeUpdate()
+			for _, stopId := range stopIds {
 				for _, stopTime := range stopTimes {
 					finalStopId := stopTimes[len(stopTimes)-1].GetStopId()
-					tripKey := [3]string{routeId, stopId, finalStopId}
-					time := time.Unix(stopTime.GetDeparture().GetTime(), 0)
 					// Exclude trips terminating at the target stop
-					if stopTime.GetStopId() == stopId && finalStopId != stopId {
-						tripToTimes[tripKey] = append(tripToTimes[tripKey], time)
+					if stopTime.GetStopId() != stopId || finalStopId == stopId {
+						continue
 					}
+
+					tripKey := [3]string{routeId, stopId, finalStopId}
+					departureTime := newRealtimeDepartureTime(trip, stopTime, schedule)
+					tripToTimes[tripKey] = append(tripToTimes[tripKey], departureTime)
 				}
 			}
 		}
 	}
 
-	stopIdToName := schedule.GetStopIdToName()
-	departures := []Departure{}
-	for tripKey, times := range tripToTimes {
-		routeId, stopId, finalStopId := tripKey[0], tripKey[1], tripKey[2]
-		departures = append(departures, Departure{
-			RouteId:       routeId,
-			StopId:        stopId,
-			FinalStopId:   finalStopId,
-			FinalStopName: stopIdToName[finalStopId],
-			Times:         times,
-		})
+	// Fall back to the schedule for routes missing from the realtime feeds
+	for _, departure := range FindScheduleDepartures(stopIds, schedule, now, now.Add(scheduleWindow)) {
+		if _, exists := realtimeRouteIds[departure.RouteId]; exists {
+			continue
+		}
+		tripKey := [3]string{departure.RouteId, departure.StopId, departure.FinalStopId}
+		tripToTimes[tripKey] = append(tripToTimes[tripKey], departure.Times...)
 	}
 
-	// Sort departures by final stop name for consistent ordering
-	slices.SortFunc(departures, func(a, b Departure) int {
-		return strings.Compare(a.FinalStopName, b.FinalStopName)
-	})
-
-	return departures
+	return newDepartures(tripToTimes, schedule)
 }
 
-// TODO: Need to find trips that are in service
-func getActiveTrips(stationId string, schedule *Schedule) {
-	now := time.Now()
-	nowDate := now.Format("20060102")
-	nowWeekday := now.Weekday()
-
-	// 1. Get active service IDs
-	serviceIds := map[string]struct{}{}
-	// 1.1 Add service ID if date is in range and weekday receives service
-	for _, calendar := range schedule.Calendars {
-		isDateInService := calendar.StartDate <= nowDate && calendar.EndDate >= nowDate
-		isWeekdayInService := calendar.IsWeekdayActive(nowWeekday)
-
-		if isDateInService && isWeekdayInService {
-			serviceIds[calendar.ServiceId] = struct{}{}
+// newRealtimeDepartureTime creates the departure time of a realtime stop time update,
+// matching it to its scheduled trip to compute the delay.
+func newRealtimeDepartureTime(trip *pb.TripDescriptor, stopTime *pb.TripUpdate_StopTimeUpdate, schedule *Schedule) DepartureTime {
+	predicted := stopTime.GetDeparture().GetTime()
+	if predicted == 0 {
+		predicted = stopTime.GetArrival().GetTime()
+	}
+
+	nyctTrip := getNyctTrip(trip)
+	departureTime := DepartureTime{
+		TripId:     trip.GetTripId(),
+		TrainId:    nyctTrip.GetTrainId(),
+		Source:     SourceRealtime,
+		Unassigned: isUnassigned(nyctTrip),
+		Track:      getTrack(stopTime),
+	}
+	if predicted != 0 {
+		departureTime.Predicted = time.Unix(predicted, 0)
+	}
+
+	isTripCancelled := trip.GetScheduleRelationship() == pb.TripDescriptor_CANCELED
+	isStopSkipped := stopTime.GetScheduleRelationship() == pb.TripUpdate_StopTimeUpdate_SKIPPED
+	if isTripCancelled || isStopSkipped {
+		departureTime.Source = SourceCancelled
+	}
+
+	if scheduled, ok := schedule.findScheduledTime(trip, stopTime.GetStopId()); ok {
+		departureTime.Scheduled = scheduled
+		if !departureTime.Predicted.IsZero() {
+			departureTime.Delay = departureTime.Predicted.Sub(scheduled)
 		}
 	}
-	// 1.2 Add or remove service IDs based on calendar date exceptions
-	for _, calendarDate := range schedule.CalendarDates {
-		if calendarDate.Date == nowDate {
-			if calendarDate.ExceptionType == 1 { // Added service
-				serviceIds[calendarDate.ServiceId] = struct{}{}
-			} else if calendarDate.ExceptionType == 2 { // Cancelled service
-				delete(serviceIds, calendarDate.ServiceId)
-			} else {
-				log.Printf("invalid exception type on calendar date: %v", calendarDate)
-			}
+
+	return departureTime
+}
+
+// findScheduledTime returns the scheduled departure of a realtime trip from stopId.
+func (s *Schedule) findScheduledTime(trip *pb.TripDescriptor, stopId string) (time.Time, bool) {
+	stopTimes, serviceDay, ok := s.findScheduledStopTimes(trip)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	for _, stopTime := range stopTimes {
+		if stopTime.StopId == stopId {
+			scheduled, err := ParseTime(serviceDay, stopTime.DepartureTime)
+			return scheduled, err == nil
 		}
 	}
-	log.Printf("Got active service IDs: %+v\n", len(serviceIds))
+	return time.Time{}, false
 }
 
-// 1. Need day of the week to determine time.Time of departure
-// 2. Need to apply calendar exceptions to determine active trips
-func FindScheduleDepartures(stopIds []string, schedule *Schedule) []Departure {
-	tripIdToStopTimes := make(map[string][]StopTime)
-	for _, stopTime := range schedule.StopTimes {
-		tripIdToStopTimes[stopTime.TripId] = append(tripIdToStopTimes[stopTime.TripId], stopTime)
+// findScheduledStopTimes returns the scheduled stop times of a realtime trip and the service day they are relative to.
+func (s *Schedule) findScheduledStopTimes(trip *pb.TripDescriptor) ([]StopTime, time.Time, bool) {
+	startDate, err := time.ParseInLocation(dateLayout, trip.GetStartDate(), Location)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	staticTrip, ok := s.FindTrip(trip.GetTripId(), startDate)
+	if !ok {
+		return nil, time.Time{}, false
 	}
 
-	tripToTimes := map[[3]string][]string{}
-	for _, stopId := range stopIds {
-		for _, trip := range schedule.Trips { // TODO: Replace schedule.Trips with active trips
-			routeId := trip.RouteId
-			stopTimes := tripIdToStopTimes[trip.TripId]
-			for _, stopTime := range stopTimes {
-				finalStopId := stopTimes[len(stopTimes)-1].StopId
-				tripKey := [3]string{routeId, stopId, finalStopId}
+	return s.GetTripIdToStopTimes()[staticTrip.TripId], ServiceDay(startDate), true
+}
+
+// FindScheduleDepartures returns the scheduled departures from the given stops between from and to.
+// Trips of the previous service day are included since they may run past midnight.
+func FindScheduleDepartures(stopIds []string, schedule *Schedule, from time.Time, to time.Time) []Departure {
+	tripIdToTrip := schedule.GetTripIdToTrip()
+	tripIdToFinalStopId := schedule.GetTripIdToFinalStopId()
+	stopIdToStopTimes := schedule.GetStopIdToStopTimes()
+
+	tripToTimes := map[[3]string][]DepartureTime{}
+	// Step through calendar dates, since the start of a service day may fall on the previous date with DST changes
+	fromDate := from.In(Location)
+	for day := -1; ; day++ {
+		date := time.Date(fromDate.Year(), fromDate.Month(), fromDate.Day()+day, 0, 0, 0, 0, Location)
+		serviceDay := ServiceDay(date)
+		if serviceDay.After(to) {
+			break
+		}
+		serviceIds := schedule.ActiveServiceIds(date)
+		for _, stopId := range stopIds {
+			for _, stopTime := range stopIdToStopTimes[stopId] {
+				trip := tripIdToTrip[stopTime.TripId]
+				if _, active := serviceIds[trip.ServiceId]; !active {
+					continue
+				}
 				// Exclude trips terminating at the target stop
-				if stopTime.StopId == stopId && finalStopId != stopId {
-					tripToTimes[tripKey] = append(tripToTimes[tripKey], stopTime.DepartureTime)
+				finalStopId := tripIdToFinalStopId[trip.TripId]
+				if finalStopId == stopId {
+					continue
+				}
+
+				scheduled, err := ParseTime(serviceDay, stopTime.DepartureTime)
+				if err != nil {
+					log.Printf("invalid departure time on stop time: %v", stopTime)
+					continue
 				}
+				if scheduled.Before(from) || scheduled.After(to) {
+					continue
+				}
+
+				tripKey := [3]string{trip.RouteId, stopId, finalStopId}
+				tripToTimes[tripKey] = append(tripToTimes[tripKey], DepartureTime{
+					TripId:    trip.TripId,
+					Scheduled: scheduled,
+					Source:    SourceScheduled,
+				})
 			}
 		}
 	}
 
+	return newDepartures(tripToTimes, schedule)
+}
+
+// newDepartures groups departure times by route, stop and final stop.
+func newDepartures(tripToTimes map[[3]string][]DepartureTime, schedule *Schedule) []Departure {
 	stopIdToName := schedule.GetStopIdToName()
 	departures := []Departure{}
-	for tripKey /*,times*/ := range tripToTimes {
+	for tripKey, times := range tripToTimes {
 		routeId, stopId, finalStopId := tripKey[0], tripKey[1], tripKey[2]
+		slices.SortFunc(times, func(a, b DepartureTime) int {
+			return a.Time().Compare(b.Time())
+		})
 		departures = append(departures, Departure{
 			RouteId:       routeId,
 			StopId:        stopId,
 			FinalStopId:   finalStopId,
 			FinalStopName: stopIdToName[finalStopId],
-			// Times:         times,
+			Times:         times,
 		})
 	}
 
@@ -134,25 +270,3 @@ func FindScheduleDepartures(stopIds []string, schedule *Schedule) []Departure {
 
 	return departures
 }
-
-func (c *Calendar) IsWeekdayActive(weekday time.Weekday) bool {
-	switch weekday {
-	case time.Monday:
-		return c.Monday
-	case time.Tuesday:
-		return c.Tuesday
-	case time.Wednesday:
-		return c.Wednesday
-	case time.Thursday:
-		return c.Thursday
-	case time.Friday:
-		return c.Friday
-	case time.Saturday:
-		return c.Saturday
-	case time.Sunday:
-		return c.Sunday
-	default:
-		log.Panicln("unreachable code: invalid weekday")
-		return false
-	}
-}
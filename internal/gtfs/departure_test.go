@@ -0,0 +1,92 @@
+package gtfs
+
+import (
+	"testing"
+	"time"
+)
+
+// weekendSchedule has one trip from L01 to L03 on Saturdays and one on Sundays, both at 08:00.
+func weekendSchedule() *Schedule {
+	return &Schedule{
+		Stops: []Stop{
+			{StopId: "L01N", StopName: "8 Av"},
+			{StopId: "L03N", StopName: "Union Sq - 14 St"},
+		},
+		Trips: []Trip{
+			{RouteId: "L", TripId: "SAT_1", ServiceId: "SAT"},
+			{RouteId: "L", TripId: "SUN_1", ServiceId: "SUN"},
+		},
+		StopTimes: []StopTime{
+			{TripId: "SAT_1", StopId: "L01N", ArrivalTime: "08:00:00", DepartureTime: "08:00:00", StopSequence: 1},
+			{TripId: "SAT_1", StopId: "L03N", ArrivalTime: "08:05:00", DepartureTime: "08:05:00", StopSequence: 2},
+			{TripId: "SUN_1", StopId: "L01N", ArrivalTime: "08:00:00", DepartureTime: "08:00:00", StopSequence: 1},
+			{TripId: "SUN_1", StopId: "L03N", ArrivalTime: "08:05:00", DepartureTime: "08:05:00", StopSequence: 2},
+		},
+		Calendars: []Calendar{
+			{ServiceId: "SAT", Saturday: true, StartDate: "20260101", EndDate: "20261231"},
+			{ServiceId: "SUN", Sunday: true, StartDate: "20260101", EndDate: "20261231"},
+		},
+	}
+}
+
+func TestServiceDayOnDSTChanges(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want time.Time
+	}{
+		// Noon minus 12h falls before midnight when clocks spring forward and after it when they fall back
+		{time.Date(2026, 3, 8, 15, 0, 0, 0, Location), time.Date(2026, 3, 8, 4, 0, 0, 0, time.UTC)},   // 23:00 EST
+		{time.Date(2026, 11, 1, 15, 0, 0, 0, Location), time.Date(2026, 11, 1, 5, 0, 0, 0, time.UTC)}, // 01:00 EDT
+		{time.Date(2026, 6, 1, 15, 0, 0, 0, Location), time.Date(2026, 6, 1, 0, 0, 0, 0, Location)},
+	}
+	for _, test := range tests {
+		if got := ServiceDay(test.date); !got.Equal(test.want) {
+			t.Errorf("ServiceDay(%v) = %v, want %v", test.date, got, test.want)
+		}
+	}
+}
+
+func TestFindScheduleDeparturesOnDSTChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   time.Time
+		tripId string
+	}{
+		{"spring forward", time.Date(2026, 3, 8, 6, 0, 0, 0, Location), "SUN_1"},
+		{"day after spring forward", time.Date(2026, 3, 9, 6, 0, 0, 0, Location), ""},
+		{"fall back", time.Date(2026, 11, 1, 6, 0, 0, 0, Location), "SUN_1"},
+		{"day before fall back", time.Date(2026, 10, 31, 6, 0, 0, 0, Location), "SAT_1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			to := test.from.Add(4 * time.Hour)
+
+			// The service day loop must terminate even when a service day starts before midnight
+			done := make(chan []Departure)
+			go func() {
+				done <- FindScheduleDepartures([]string{"L01N"}, weekendSchedule(), test.from, to)
+			}()
+			var departures []Departure
+			select {
+			case departures = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("FindScheduleDepartures did not return")
+			}
+
+			if test.tripId == "" {
+				if len(departures) != 0 {
+					t.Fatalf("got departures %v, want none", departures)
+				}
+				return
+			}
+			if len(departures) != 1 || len(departures[0].Times) != 1 {
+				t.Fatalf("got departures %v, want a single departure", departures)
+			}
+			departure := departures[0].Times[0]
+			want := time.Date(test.from.Year(), test.from.Month(), test.from.Day(), 8, 0, 0, 0, Location)
+			if departure.TripId != test.tripId || !departure.Scheduled.Equal(want) {
+				t.Errorf("got %s at %v, want %s at %v", departure.TripId, departure.Scheduled, test.tripId, want)
+			}
+		})
+	}
+}
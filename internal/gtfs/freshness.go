@@ -0,0 +1,101 @@
+package gtfs
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	StaleThreshold = 2 * time.Minute  // Age at which realtime data is considered stale
+	DeadThreshold  = 10 * time.Minute // Age at which realtime data is no longer considered live
+)
+
+// Freshness describes how old realtime data is, based on the timestamps the producer reports
+// rather than when it was fetched. A feed can keep serving the same frozen snapshot.
+type Freshness int
+
+const (
+	Fresh Freshness = iota
+	Stale           // Older than StaleThreshold, predictions may be inaccurate
+	Dead            // Older than DeadThreshold, predictions should not be trusted
+)
+
+func (f Freshness) String() string {
+	switch f {
+	case Fresh:
+		return "Fresh"
+	case Stale:
+		return "Stale"
+	case Dead:
+		return "Dead"
+	default:
+		log.Panicf("Unknown Freshness: %d", int(f))
+		return "Unknown"
+	}
+}
+
+// FreshnessOf returns the freshness of realtime data that is age old.
+func FreshnessOf(age time.Duration) Freshness {
+	switch {
+	case age >= DeadThreshold:
+		return Dead
+	case age >= StaleThreshold:
+		return Stale
+	default:
+		return Fresh
+	}
+}
+
+// Age returns how old the feed's last good message is at now according to its header timestamp.
+// Zero is returned if there is no message or it is not timestamped.
+func (fs FeedState) Age(now time.Time) time.Duration {
+	headerTimestamp := fs.Msg.GetHeader().GetTimestamp()
+	if headerTimestamp == 0 {
+		return 0
+	}
+	return max(0, now.Sub(time.Unix(int64(headerTimestamp), 0)))
+}
+
+// RouteAges returns how old the realtime data of each route is at now: the age of its most recently
+// updated trip, or of its feed's header if its trips are not timestamped. A trip can never be newer
+// than the header of the message it is in.
+func (r *Realtime) RouteAges(now time.Time) map[string]time.Duration {
+	routeIdToTimestamp := map[string]uint64{}
+	for _, feed := range r.Feeds {
+		headerTimestamp := feed.Msg.GetHeader().GetTimestamp()
+		if headerTimestamp == 0 {
+			continue
+		}
+
+		// Untimestamped trips only count when none of the route's trips are timestamped
+		feedRouteIdToTimestamp := map[string]uint64{}
+		for _, feedEntity := range feed.Msg.GetEntity() {
+			tripUpdate := feedEntity.GetTripUpdate()
+			routeId := tripUpdate.GetTrip().GetRouteId()
+			if routeId == "" {
+				continue
+			}
+			if _, exists := feedRouteIdToTimestamp[routeId]; !exists {
+				feedRouteIdToTimestamp[routeId] = 0
+			}
+			timestamp := min(tripUpdate.GetTimestamp(), headerTimestamp)
+			feedRouteIdToTimestamp[routeId] = max(feedRouteIdToTimestamp[routeId], timestamp)
+		}
+		for routeId, timestamp := range feedRouteIdToTimestamp {
+			if timestamp == 0 {
+				feedRouteIdToTimestamp[routeId] = headerTimestamp
+			}
+		}
+
+		// Routes split across feeds are as fresh as their freshest feed
+		for routeId, timestamp := range feedRouteIdToTimestamp {
+			routeIdToTimestamp[routeId] = max(routeIdToTimestamp[routeId], timestamp)
+		}
+	}
+
+	routeIdToAge := map[string]time.Duration{}
+	for routeId, timestamp := range routeIdToTimestamp {
+		routeIdToAge[routeId] = max(0, now.Sub(time.Unix(int64(timestamp), 0)))
+	}
+	return routeIdToAge
+}
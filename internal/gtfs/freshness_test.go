@@ -0,0 +1,105 @@
+package gtfs
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/pb"
+)
+
+func testTripUpdateEntity(tripId string, routeId string, timestamp uint64) *pb.FeedEntity {
+	tripUpdate := &pb.TripUpdate{
+		Trip: &pb.TripDescriptor{TripId: proto.String(tripId), RouteId: proto.String(routeId)},
+	}
+	if timestamp != 0 {
+		tripUpdate.Timestamp = proto.Uint64(timestamp)
+	}
+	return &pb.FeedEntity{Id: proto.String(tripId), TripUpdate: tripUpdate}
+}
+
+func TestFreshnessOf(t *testing.T) {
+	tests := []struct {
+		age  time.Duration
+		want Freshness
+	}{
+		{0, Fresh},
+		{StaleThreshold - time.Second, Fresh},
+		{StaleThreshold, Stale},
+		{DeadThreshold - time.Second, Stale},
+		{DeadThreshold, Dead},
+		{time.Hour, Dead},
+	}
+	for _, test := range tests {
+		if got := FreshnessOf(test.age); got != test.want {
+			t.Errorf("FreshnessOf(%v) = %v, want %v", test.age, got, test.want)
+		}
+	}
+}
+
+func TestRouteAges(t *testing.T) {
+	now := time.Unix(10_000, 0)
+
+	tests := []struct {
+		name  string
+		feeds []FeedState
+		want  map[string]time.Duration
+	}{
+		{
+			name: "newest trip",
+			feeds: []FeedState{{Name: "gtfs-l", Msg: testFeedMessage(9_990,
+				testTripUpdateEntity("1", "L", 9_700),
+				testTripUpdateEntity("2", "L", 9_900),
+			)}},
+			want: map[string]time.Duration{"L": 100 * time.Second},
+		},
+		{
+			name:  "untimestamped trips use the header",
+			feeds: []FeedState{{Name: "gtfs-l", Msg: testFeedMessage(9_990, testTripUpdateEntity("1", "L", 0))}},
+			want:  map[string]time.Duration{"L": 10 * time.Second},
+		},
+		{
+			name: "untimestamped trips ignored beside timestamped ones",
+			feeds: []FeedState{{Name: "gtfs-l", Msg: testFeedMessage(9_990,
+				testTripUpdateEntity("1", "L", 0),
+				testTripUpdateEntity("2", "L", 9_000),
+			)}},
+			want: map[string]time.Duration{"L": 1000 * time.Second},
+		},
+		{
+			name:  "trip newer than header",
+			feeds: []FeedState{{Name: "gtfs-l", Msg: testFeedMessage(9_400, testTripUpdateEntity("1", "L", 9_990))}},
+			want:  map[string]time.Duration{"L": 600 * time.Second},
+		},
+		{
+			name: "route split across feeds",
+			feeds: []FeedState{
+				{Name: "gtfs-ace", Msg: testFeedMessage(9_000, testTripUpdateEntity("1", "A", 9_000))},
+				{Name: "gtfs-a2", Msg: testFeedMessage(9_880, testTripUpdateEntity("2", "A", 9_880))},
+			},
+			want: map[string]time.Duration{"A": 120 * time.Second},
+		},
+		{
+			name: "untimestamped header",
+			feeds: []FeedState{
+				{Name: "gtfs-l", Msg: testFeedMessage(0, testTripUpdateEntity("1", "L", 9_990))},
+				{Name: "gtfs-g"},
+			},
+			want: map[string]time.Duration{},
+		},
+		{
+			name:  "header from the future",
+			feeds: []FeedState{{Name: "gtfs-l", Msg: testFeedMessage(10_060, testTripUpdateEntity("1", "L", 0))}},
+			want:  map[string]time.Duration{"L": 0},
+		},
+	}
+	for _, test := range tests {
+		realtime := &Realtime{Feeds: test.feeds}
+		got := realtime.RouteAges(now)
+		if !maps.Equal(got, test.want) {
+			t.Errorf("%s: RouteAges = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package gtfs
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	earthRadius   = 6371000.0 // Mean radius of the earth in meters
+	gridCellSize  = 0.01      // Size in degrees of a station grid cell, about 1.1 km of latitude
+	walkingSpeed  = 80.0      // Meters per minute, about 3 mph
+	walkingDetour = 1.3       // Walking routes follow the street grid rather than a straight line
+)
+
+// Coordinates is a point on the earth in degrees.
+type Coordinates struct {
+	Lat float64
+	Lon float64
+}
+
+// ParseCoordinates parses comma separated latitude and longitude, e.g. "40.73,-73.99".
+func ParseCoordinates(s string) (Coordinates, error) {
+	latStr, lonStr, found := strings.Cut(s, ",")
+	if !found {
+		return Coordinates{}, fmt.Errorf("invalid coordinates %q: expected latitude,longitude", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return Coordinates{}, fmt.Errorf("invalid latitude %q", latStr)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return Coordinates{}, fmt.Errorf("invalid longitude %q", lonStr)
+	}
+	return Coordinates{Lat: lat, Lon: lon}, nil
+}
+
+func (s Stop) Coordinates() Coordinates {
+	return Coordinates{Lat: s.StopLat, Lon: s.StopLon}
+}
+
+// Distance returns the great-circle distance in meters between a and b.
+func Distance(a Coordinates, b Coordinates) float64 {
+	lat1, lat2 := toRadians(a.Lat), toRadians(b.Lat)
+	dLat, dLon := lat2-lat1, toRadians(b.Lon-a.Lon)
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(min(1, h)))
+}
+
+// WalkingTime estimates how long it takes to walk between points distance meters apart.
+func WalkingTime(distance float64) time.Duration {
+	return time.Duration(distance * walkingDetour / walkingSpeed * float64(time.Minute))
+}
+
+type NearbyStation struct {
+	Station
+	Distance float64 // Meters from the point searched from
+}
+
+// FindNearbyStations returns the limit stations closest to coordinates, nearest first.
+// A limit of 0 or less returns every station.
+func (s *Schedule) FindNearbyStations(coordinates Coordinates, limit int) []NearbyStation {
+	stations := s.GetStations()
+	grid := s.getStationGrid()
+	center := gridCell(coordinates)
+	// Rings around a point away from every station only grow toward them, so sorting is cheaper
+	if limit <= 0 || limit >= len(stations) || !grid.contains(center) {
+		nearby := make([]NearbyStation, len(stations))
+		for i, station := range stations {
+			nearby[i] = NearbyStation{Station: station, Distance: Distance(coordinates, station.Coordinates())}
+		}
+		slices.SortFunc(nearby, func(a, b NearbyStation) int {
+			return cmp.Compare(a.Distance, b.Distance)
+		})
+		if limit > 0 && limit < len(nearby) {
+			nearby = nearby[:limit]
+		}
+		return nearby
+	}
+
+	// Search rings of grid cells around the point until no unsearched cell can be closer
+	// than the farthest station found so far, or every cell with stations has been searched
+	cellMeters := gridCellSize * math.Pi / 180 * earthRadius * math.Cos(toRadians(min(math.Abs(coordinates.Lat), 89)))
+	nearby := []NearbyStation{}
+	for ring := 0; ring <= grid.coveringRing(center); ring++ {
+		if len(nearby) >= limit && nearby[limit-1].Distance <= float64(ring-1)*cellMeters {
+			break
+		}
+		for _, cell := range gridRing(center, ring) {
+			for _, i := range grid.cells[cell] {
+				nearby = append(nearby, NearbyStation{
+					Station:  stations[i],
+					Distance: Distance(coordinates, stations[i].Coordinates()),
+				})
+			}
+		}
+		slices.SortFunc(nearby, func(a, b NearbyStation) int {
+			return cmp.Compare(a.Distance, b.Distance)
+		})
+	}
+
+	return nearby[:limit]
+}
+
+// stationGrid buckets the indices of stations in GetStations by grid cell.
+type stationGrid struct {
+	cells    map[[2]int][]int
+	min, max [2]int // Bounding box of the cells with stations
+}
+
+// contains reports whether cell is within the bounding box of the grid.
+func (g *stationGrid) contains(cell [2]int) bool {
+	return len(g.cells) > 0 &&
+		cell[0] >= g.min[0] && cell[0] <= g.max[0] &&
+		cell[1] >= g.min[1] && cell[1] <= g.max[1]
+}
+
+// coveringRing returns the smallest ring around center by which every cell of the grid has been searched.
+func (g *stationGrid) coveringRing(center [2]int) int {
+	return max(
+		center[0]-g.min[0], g.max[0]-center[0],
+		center[1]-g.min[1], g.max[1]-center[1],
+	)
+}
+
+func (s *Schedule) getStationGrid() *stationGrid {
+	if s.cache.stationGrid != nil {
+		return s.cache.stationGrid
+	}
+
+	grid := &stationGrid{cells: map[[2]int][]int{}}
+	for i, station := range s.GetStations() {
+		cell := gridCell(station.Coordinates())
+		grid.cells[cell] = append(grid.cells[cell], i)
+		if i == 0 {
+			grid.min, grid.max = cell, cell
+		}
+		grid.min = [2]int{min(grid.min[0], cell[0]), min(grid.min[1], cell[1])}
+		grid.max = [2]int{max(grid.max[0], cell[0]), max(grid.max[1], cell[1])}
+	}
+
+	s.cache.stationGrid = grid
+	return grid
+}
+
+func gridCell(coordinates Coordinates) [2]int {
+	return [2]int{
+		int(math.Floor(coordinates.Lat / gridCellSize)),
+		int(math.Floor(coordinates.Lon / gridCellSize)),
+	}
+}
+
+// gridRing returns the cells at exactly ring cells from center, i.e. the perimeter of a square.
+func gridRing(center [2]int, ring int) [][2]int {
+	if ring == 0 {
+		return [][2]int{center}
+	}
+	cells := [][2]int{}
+	for d := -ring; d <= ring; d++ {
+		cells = append(cells,
+			[2]int{center[0] - ring, center[1] + d},
+			[2]int{center[0] + ring, center[1] + d},
+		)
+		if d != -ring && d != ring {
+			cells = append(cells,
+				[2]int{center[0] + d, center[1] - ring},
+				[2]int{center[0] + d, center[1] + ring},
+			)
+		}
+	}
+	return cells
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
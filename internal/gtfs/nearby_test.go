@@ -0,0 +1,42 @@
+package gtfs
+
+import (
+	"fmt"
+	"testing"
+)
+
+// gridSchedule has stations spread over a 10 by 10 km area of Manhattan and Brooklyn.
+func gridSchedule() *Schedule {
+	schedule := &Schedule{}
+	for i := range 100 {
+		schedule.Stops = append(schedule.Stops, Stop{
+			StopId:       fmt.Sprintf("S%02d", i),
+			StopLat:      40.65 + float64(i/10)*0.009,
+			StopLon:      -74.0 + float64(i%10)*0.011,
+			LocationType: 1,
+		})
+	}
+	return schedule
+}
+
+func TestFindNearbyStationsMatchesSort(t *testing.T) {
+	schedule := gridSchedule()
+	points := []Coordinates{
+		{Lat: 40.7, Lon: -73.95},   // Among the stations
+		{Lat: 40.5, Lon: -74.2},    // Outside the stations
+		{Lat: -73.99, Lon: 40.73},  // Latitude and longitude swapped
+		{Lat: 40.653, Lon: -73.99}, // Near the edge
+	}
+	for _, point := range points {
+		all := schedule.FindNearbyStations(point, 0)
+		nearby := schedule.FindNearbyStations(point, 5)
+		if len(nearby) != 5 {
+			t.Fatalf("FindNearbyStations(%v, 5) returned %d stations", point, len(nearby))
+		}
+		for i := range nearby {
+			if nearby[i].StopId != all[i].StopId {
+				t.Errorf("FindNearbyStations(%v, 5)[%d] = %s, want %s", point, i, nearby[i].StopId, all[i].StopId)
+			}
+		}
+	}
+}
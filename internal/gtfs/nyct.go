@@ -0,0 +1,53 @@
+package gtfs
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/pb"
+)
+
+// Track is the track a train uses at a stop, per the NYCT realtime extensions.
+type Track struct {
+	Scheduled string // Track the train is scheduled to use
+	Actual    string // Track the train is actually using, empty until it is known
+}
+
+// String returns the actual track, or the scheduled track if the actual track is unknown.
+func (t Track) String() string {
+	if t.Actual != "" {
+		return t.Actual
+	}
+	return t.Scheduled
+}
+
+// Changed reports whether the train is using a different track than scheduled.
+func (t Track) Changed() bool {
+	return t.Scheduled != "" && t.Actual != "" && t.Scheduled != t.Actual
+}
+
+// getNyctTrip returns the NYCT extension of a realtime trip, or nil if the feed does not provide it.
+func getNyctTrip(trip *pb.TripDescriptor) *pb.NyctTripDescriptor {
+	if trip == nil || !proto.HasExtension(trip, pb.E_NyctTripDescriptor) {
+		return nil
+	}
+	nyctTrip, _ := proto.GetExtension(trip, pb.E_NyctTripDescriptor).(*pb.NyctTripDescriptor)
+	return nyctTrip
+}
+
+// getTrack returns the track of a realtime stop time update, which is zero if the feed does not provide it.
+func getTrack(stopTime *pb.TripUpdate_StopTimeUpdate) Track {
+	if stopTime == nil || !proto.HasExtension(stopTime, pb.E_NyctStopTimeUpdate) {
+		return Track{}
+	}
+	nyctStopTime, _ := proto.GetExtension(stopTime, pb.E_NyctStopTimeUpdate).(*pb.NyctStopTimeUpdate)
+	return Track{
+		Scheduled: nyctStopTime.GetScheduledTrack(),
+		Actual:    nyctStopTime.GetActualTrack(),
+	}
+}
+
+// isUnassigned reports whether a NYCT trip has not been assigned a train yet. Trips
+// without the NYCT extension are considered assigned.
+func isUnassigned(nyctTrip *pb.NyctTripDescriptor) bool {
+	return nyctTrip != nil && !nyctTrip.GetIsAssigned()
+}
@@ -1,11 +1,16 @@
 package gtfs
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -14,64 +19,239 @@ import (
 	"nyct-feed/internal/pb"
 )
 
-var feedUrls = [8]string{
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-ace",
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-bdfm",
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-g",
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-jz",
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-nqrw",
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-l",
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs",
-	"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-si",
+// Realtime is the latest state of every polled realtime feed.
+type Realtime struct {
+	Feeds []FeedState
 }
 
-// GetRealtime fetches GTFS updates for all realtime feeds concurrently
-func GetRealtime() ([]*pb.FeedMessage, error) {
-	msgs := make([]*pb.FeedMessage, len(feedUrls))
-	var g errgroup.Group
+// FeedState is the outcome of polling a single realtime feed.
+// A feed that fails to fetch keeps the last message that was fetched successfully.
+type FeedState struct {
+	Name          string
+	Msg           *pb.FeedMessage // Last message fetched successfully, nil if none has been
+	UpdatedAt     time.Time       // When Msg was fetched
+	Err           error           // Error of the most recent fetch, nil if it succeeded
+	FailedAt      time.Time       // When the most recent fetch failed
+	FetchDuration time.Duration   // How long the most recent fetch took
+	PayloadBytes  int             // Size of Msg as fetched, e.g. the length of the HTTP response body
+	Fetches       int             // Number of fetches, including failed ones
+	Failures      int             // Number of failed fetches
+	// UpdateInterval is the shortest step the header timestamp has been seen advancing by, zero until it has been seen changing.
+	// This is how often the producer publishes the feed: updates missed between polls, e.g. while idle, only lengthen a step.
+	UpdateInterval time.Duration
+}
 
-	for i, feedUrl := range feedUrls {
-		g.Go(func() error {
-			i, feedUrl := i, feedUrl // capture loop variables
+// Degraded reports whether the most recent fetch of the feed failed.
+func (fs FeedState) Degraded() bool {
+	return fs.Err != nil
+}
 
-			msg, err := fetchFeedMessage(feedUrl)
-			if err != nil {
-				return err
-			}
+// AlertsOnly reports whether the feed only carries service alerts, so departures do not depend on it.
+// A known alert feed is recognized before any message has been fetched from it.
+func (fs FeedState) AlertsOnly() bool {
+	if _, exists := alertFeedNames[fs.Name]; exists {
+		return true
+	}
+	if len(fs.Msg.GetEntity()) == 0 {
+		return false
+	}
+	for _, feedEntity := range fs.Msg.GetEntity() {
+		if feedEntity.GetAlert() == nil {
+			return false
+		}
+	}
+	return true
+}
 
-			msgs[i] = msg
-			return nil
-		})
+// RouteIds returns the IDs of the routes with trips in the feed's last message.
+// Until a message has been fetched, the routes a known feed carries are returned instead; empty if the feed is unknown.
+func (fs FeedState) RouteIds() map[string]struct{} {
+	routeIds := map[string]struct{}{}
+	if fs.Msg == nil {
+		for _, routeId := range feedNameToRouteIds[fs.Name] {
+			routeIds[routeId] = struct{}{}
+		}
+		return routeIds
+	}
+	for _, feedEntity := range fs.Msg.GetEntity() {
+		if routeId := feedEntity.GetTripUpdate().GetTrip().GetRouteId(); routeId != "" {
+			routeIds[routeId] = struct{}{}
+		}
 	}
+	return routeIds
+}
 
-	if err := g.Wait(); err != nil {
-		return nil, fmt.Errorf("failed to fetch feeds: %v", err)
+// Messages returns the last message of every feed that has been fetched successfully.
+func (r *Realtime) Messages() []*pb.FeedMessage {
+	msgs := []*pb.FeedMessage{}
+	for _, feed := range r.Feeds {
+		if feed.Msg != nil {
+			msgs = append(msgs, feed.Msg)
+		}
 	}
-	return msgs, nil
+	return msgs
 }
 
-func fetchFeedMessage(feedUrl string) (*pb.FeedMessage, error) {
-	resp, err := http.Get(feedUrl)
-	if err != nil {
-		return nil, err
+// DegradedFeeds returns the feeds whose most recent fetch failed.
+func (r *Realtime) DegradedFeeds() []FeedState {
+	degraded := []FeedState{}
+	for _, feed := range r.Feeds {
+		if feed.Degraded() {
+			degraded = append(degraded, feed)
+		}
 	}
-	defer resp.Body.Close()
+	return degraded
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// RealtimePoller polls realtime feed sources, keeping the last good message of feeds that fail.
+type RealtimePoller struct {
+	// ObserveFetch, if set, is called with how long each feed's fetch took as soon as it ends, including failed
+	// fetches and those of abandoned polls. It is called concurrently and must be set before polling.
+	ObserveFetch func(feedName string, duration time.Duration)
+
+	sources []FeedSource
+	mu      sync.Mutex   // Serializes polls
+	feedsMu sync.RWMutex // Guards feeds, which are updated once every feed of a poll has been fetched
+	feeds   []FeedState
+}
+
+func NewRealtimePoller(sources []FeedSource) *RealtimePoller {
+	feeds := make([]FeedState, len(sources))
+	for i, source := range sources {
+		feeds[i].Name = source.Name()
+	}
+	return &RealtimePoller{sources: sources, feeds: feeds}
+}
+
+// Poll fetches GTFS updates for all realtime feed sources concurrently.
+// A failing feed is reported in its FeedState rather than failing the poll;
+// an error is only returned if no feed has ever been fetched successfully.
+// If ctx is done before every feed has been fetched, the poll is abandoned and no feed state is updated.
+func (p *RealtimePoller) Poll(ctx context.Context) (*Realtime, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	type fetchResult struct {
+		snapshot Snapshot
+		err      error
+		duration time.Duration
 	}
+	results := make([]fetchResult, len(p.sources))
 
-	msg := &pb.FeedMessage{}
-	if err := proto.Unmarshal(body, msg); err != nil {
+	var wg sync.WaitGroup
+	for i, source := range p.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			snapshot, err := source.Fetch(ctx)
+			results[i] = fetchResult{snapshot, err, time.Since(start)}
+			if p.ObserveFetch != nil {
+				p.ObserveFetch(source.Name(), results[i].duration)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	return msg, nil
+	p.feedsMu.Lock()
+	for i, result := range results {
+		feed := &p.feeds[i]
+		feed.Fetches++
+		feed.FetchDuration = result.duration
+		if result.err != nil {
+			feed.Failures++
+			feed.Err = result.err
+			feed.FailedAt = time.Now()
+			continue
+		}
+		msg := result.snapshot.Msg
+		prevTimestamp, timestamp := feed.Msg.GetHeader().GetTimestamp(), msg.GetHeader().GetTimestamp()
+		if prevTimestamp != 0 && timestamp > prevTimestamp {
+			step := time.Duration(timestamp-prevTimestamp) * time.Second
+			if feed.UpdateInterval == 0 || step < feed.UpdateInterval {
+				feed.UpdateInterval = step
+			}
+		}
+		feed.Msg = msg
+		feed.PayloadBytes = len(result.snapshot.Data)
+		if result.snapshot.Data == nil {
+			feed.PayloadBytes = proto.Size(msg) // Decoded from another format, e.g. a JSON snapshot
+		}
+		feed.UpdatedAt = time.Now()
+		feed.Err = nil
+	}
+	realtime := &Realtime{Feeds: slices.Clone(p.feeds)}
+	p.feedsMu.Unlock()
+
+	if len(realtime.Messages()) == 0 {
+		errs := []error{}
+		for _, feed := range realtime.Feeds {
+			errs = append(errs, fmt.Errorf("%s: %v", feed.Name, feed.Err))
+		}
+		return nil, fmt.Errorf("failed to fetch feeds: %v", errors.Join(errs...))
+	}
+	return realtime, nil
+}
+
+// Feeds returns the state of every feed as of the most recent poll.
+func (p *RealtimePoller) Feeds() []FeedState {
+	p.feedsMu.RLock()
+	defer p.feedsMu.RUnlock()
+	return slices.Clone(p.feeds)
+}
+
+// RecordRealtime fetches every feed source once and appends new snapshots to the archive.
+// When writeJson is set, new snapshots are also written as JSON for debugging.
+// Returns the number of snapshots archived; a failing feed does not prevent the others from being recorded.
+func RecordRealtime(ctx context.Context, sources []FeedSource, archive *Archive, writeJson bool) (int, error) {
+	var archived atomic.Int32
+	var g errgroup.Group
+
+	for _, source := range sources {
+		g.Go(func() error {
+			snapshot, err := source.Fetch(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to fetch feed %s: %v", source.Name(), err)
+			}
+
+			ok, err := archive.Append(source.Name(), snapshot)
+			if err != nil {
+				return fmt.Errorf("failed to archive feed %s: %v", source.Name(), err)
+			}
+			if !ok {
+				return nil // Duplicate snapshot
+			}
+			archived.Add(1)
+
+			if writeJson {
+				writeFeedMessage(source.Name(), snapshot.Msg)
+			}
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	return int(archived.Load()), err
+}
+
+// FeedTimestamp returns the oldest header timestamp of the given feed messages.
+// This is the age of the realtime data as a whole, regardless of when it was fetched.
+func FeedTimestamp(msgs []*pb.FeedMessage) time.Time {
+	oldest := time.Time{}
+	for _, msg := range msgs {
+		timestamp := time.Unix(int64(msg.GetHeader().GetTimestamp()), 0)
+		if oldest.IsZero() || timestamp.Before(oldest) {
+			oldest = timestamp
+		}
+	}
+	return oldest
 }
 
-// Write feed messages to /out. Helpful for debugging
-func writeFeedMessage(msg *pb.FeedMessage) {
+// Write a feed message to the data directory as a JSON snapshot. Helpful for debugging
+func writeFeedMessage(feedName string, msg *pb.FeedMessage) {
 	marshallOptions := protojson.MarshalOptions{
 		Indent: "  ",
 	}
@@ -81,12 +261,13 @@ func writeFeedMessage(msg *pb.FeedMessage) {
 		log.Fatal(err)
 	}
 
-	err = os.MkdirAll(dataDir, dirPerms)
+	feedDir := filepath.Join(feedsDir, feedName)
+	err = os.MkdirAll(feedDir, dirPerms)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	outFile := fmt.Sprintf("%smta-feed-%d.json", dataDir, *msg.Header.Timestamp)
+	outFile := filepath.Join(feedDir, fmt.Sprintf("%d.json", msg.GetHeader().GetTimestamp()))
 
 	err = os.WriteFile(outFile, feedJson, filePerms)
 	if err != nil {
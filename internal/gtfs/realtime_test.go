@@ -0,0 +1,178 @@
+package gtfs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/pb"
+)
+
+func TestPollReportsFailingFeeds(t *testing.T) {
+	poller := NewRealtimePoller([]FeedSource{
+		MemorySource{FeedName: "gtfs-ace", Msg: testFeedMessage(100)},
+		MemorySource{FeedName: "gtfs-l", Err: errors.New("connection refused")},
+	})
+
+	realtime, err := poller.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if got := len(realtime.Messages()); got != 1 {
+		t.Errorf("got %d messages, want 1", got)
+	}
+	degraded := realtime.DegradedFeeds()
+	if len(degraded) != 1 || degraded[0].Name != "gtfs-l" {
+		t.Errorf("degraded feeds = %v, want gtfs-l", degraded)
+	}
+}
+
+func TestPollFailsWithoutAnyMessage(t *testing.T) {
+	poller := NewRealtimePoller([]FeedSource{
+		MemorySource{FeedName: "gtfs-l", Err: errors.New("connection refused")},
+	})
+
+	if _, err := poller.Poll(context.Background()); err == nil {
+		t.Fatal("Poll succeeded, want an error")
+	}
+}
+
+func TestRecordRealtimeSkipsDuplicates(t *testing.T) {
+	archive := NewArchive(t.TempDir(), 1<<20)
+	sources := []FeedSource{
+		MemorySource{FeedName: "gtfs-ace", Msg: testFeedMessage(100)},
+		MemorySource{FeedName: "gtfs-l", Msg: testFeedMessage(100)},
+	}
+
+	for i, want := range []int{2, 0} {
+		archived, err := RecordRealtime(context.Background(), sources, archive, false)
+		if err != nil {
+			t.Fatalf("RecordRealtime: %v", err)
+		}
+		if archived != want {
+			t.Errorf("recording %d archived %d snapshots, want %d", i+1, archived, want)
+		}
+	}
+}
+
+func TestRouteIdsOfUnfetchedFeed(t *testing.T) {
+	routeIds := FeedState{Name: "gtfs-l"}.RouteIds()
+	if _, exists := routeIds["L"]; !exists || len(routeIds) != 1 {
+		t.Errorf("RouteIds() = %v, want L", routeIds)
+	}
+	if routeIds := (FeedState{Name: "subway-alerts"}).RouteIds(); len(routeIds) != 0 {
+		t.Errorf("RouteIds() of an unknown feed = %v, want none", routeIds)
+	}
+}
+
+func TestPollRecordsPayloadBytes(t *testing.T) {
+	// Concatenated messages merge when decoded, so the body is larger than the decoded message
+	body, err := proto.Marshal(testFeedMessage(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := proto.Marshal(testFeedMessage(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = append(body, more...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	realtime, err := NewRealtimePoller([]FeedSource{HTTPSource{URL: server.URL}}).Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if got := realtime.Feeds[0].PayloadBytes; got != len(body) {
+		t.Errorf("PayloadBytes = %d, want the body length %d", got, len(body))
+	}
+}
+
+// sequenceSource serves the given feed messages in turn, one per fetch.
+type sequenceSource struct {
+	msgs []*pb.FeedMessage
+	next int
+}
+
+func (s *sequenceSource) Name() string {
+	return "gtfs-l"
+}
+
+func (s *sequenceSource) Fetch(ctx context.Context) (Snapshot, error) {
+	msg := s.msgs[min(s.next, len(s.msgs)-1)]
+	s.next++
+	return Snapshot{Msg: msg}, nil
+}
+
+func TestPollUpdateInterval(t *testing.T) {
+	tests := []struct {
+		name       string
+		timestamps []uint64
+		want       time.Duration
+	}{
+		{"unchanged", []uint64{100, 100}, 0},
+		{"steady", []uint64{100, 130, 160}, 30 * time.Second},
+		// Updates missed while idle do not lengthen the interval
+		{"after idle", []uint64{100, 130, 730, 760}, 30 * time.Second},
+		{"missed update first", []uint64{100, 160, 190}, 30 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := &sequenceSource{}
+			for _, timestamp := range test.timestamps {
+				source.msgs = append(source.msgs, testFeedMessage(timestamp))
+			}
+			poller := NewRealtimePoller([]FeedSource{source})
+
+			var realtime *Realtime
+			for range test.timestamps {
+				var err error
+				if realtime, err = poller.Poll(context.Background()); err != nil {
+					t.Fatalf("Poll: %v", err)
+				}
+			}
+			if got := realtime.Feeds[0].UpdateInterval; got != test.want {
+				t.Errorf("UpdateInterval = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPollObservesEveryFetch(t *testing.T) {
+	poller := NewRealtimePoller([]FeedSource{
+		MemorySource{FeedName: "gtfs-ace", Msg: testFeedMessage(100)},
+		MemorySource{FeedName: "gtfs-l", Err: errors.New("connection refused")},
+	})
+	var mu sync.Mutex
+	observed := map[string]int{}
+	poller.ObserveFetch = func(feedName string, duration time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		observed[feedName]++
+	}
+
+	if _, err := poller.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	// An abandoned poll leaves the feed states unchanged, but its fetches still happened
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := poller.Poll(ctx); err == nil {
+		t.Fatal("Poll with a cancelled context succeeded, want an error")
+	}
+
+	if observed["gtfs-ace"] != 2 || observed["gtfs-l"] != 2 {
+		t.Errorf("observed fetches %v, want 2 of each feed", observed)
+	}
+	if feeds := poller.Feeds(); feeds[0].Fetches != 1 {
+		t.Errorf("feed state counts %d fetches, want the completed poll's only", feeds[0].Fetches)
+	}
+}
@@ -2,16 +2,29 @@ package gtfs
 
 import (
 	"archive/zip"
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"nyct-feed/internal/csvutil"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-const scheduleUrl = "https://rrgtfsfeeds.s3.amazonaws.com/gtfs_supplemented.zip"
+const (
+	scheduleUrl            = "https://rrgtfsfeeds.s3.amazonaws.com/gtfs_supplemented.zip"
+	scheduleZipFile        = "gtfs_supplemented.zip"
+	scheduleValidatorsFile = "gtfs_supplemented.json"
+	// scheduleTimeout limits downloading the schedule, which is tens of megabytes
+	scheduleTimeout = 5 * time.Minute
+)
 
 type Schedule struct {
 	Stops         []Stop         `file:"stops.txt"`
@@ -25,8 +38,16 @@ type Schedule struct {
 
 // Cached values derived from schedule
 type scheduleCache struct {
-	stopIdToName map[string]string
-	stations     []Station
+	stopIdToName        map[string]string
+	stations            []Station
+	tripIdToTrip        map[string]Trip
+	tripIdToFinalStopId map[string]string
+	stopIdToStopTimes   map[string][]StopTime
+	tripIdToStopTimes   map[string][]StopTime
+	realtimeIdToTrips   map[string][]Trip
+	stationGrid         *stationGrid
+	// fromCache is set when the schedule was read from the cache without revalidating it
+	fromCache bool
 }
 
 type Station struct {
@@ -119,7 +140,7 @@ func (s *Schedule) GetStations() []Station {
 	}
 
 	// Filter and populate stations
-	var stations []Station
+	stations := []Station{} // Non-nil so that a schedule without stations is cached too
 	for _, stop := range s.Stops {
 		if stop.LocationType == 1 {
 			routeIds := stationIdToRouteIds[stop.StopId]
@@ -152,16 +173,192 @@ func (s *Schedule) GetStopIdToName() map[string]string {
 	return stopIdToName
 }
 
-// GetSchedule fetches a GTFS schedule containing all schedule files.
-func GetSchedule() (*Schedule, error) {
-	scheduleFiles, err := fetchScheduleFiles()
+func (s *Schedule) GetTripIdToTrip() map[string]Trip {
+	if s.cache.tripIdToTrip != nil {
+		return s.cache.tripIdToTrip
+	}
+
+	tripIdToTrip := make(map[string]Trip, len(s.Trips))
+	for _, trip := range s.Trips {
+		tripIdToTrip[trip.TripId] = trip
+	}
+
+	s.cache.tripIdToTrip = tripIdToTrip
+	return tripIdToTrip
+}
+
+// GetTripIdToFinalStopId maps each trip to the stop with its greatest stop sequence.
+func (s *Schedule) GetTripIdToFinalStopId() map[string]string {
+	if s.cache.tripIdToFinalStopId != nil {
+		return s.cache.tripIdToFinalStopId
+	}
+
+	tripIdToFinalStopId := make(map[string]string, len(s.Trips))
+	tripIdToFinalSequence := make(map[string]int, len(s.Trips))
+	for _, stopTime := range s.StopTimes {
+		sequence, exists := tripIdToFinalSequence[stopTime.TripId]
+		if !exists || stopTime.StopSequence > sequence {
+			tripIdToFinalSequence[stopTime.TripId] = stopTime.StopSequence
+			tripIdToFinalStopId[stopTime.TripId] = stopTime.StopId
+		}
+	}
+
+	s.cache.tripIdToFinalStopId = tripIdToFinalStopId
+	return tripIdToFinalStopId
+}
+
+func (s *Schedule) GetStopIdToStopTimes() map[string][]StopTime {
+	if s.cache.stopIdToStopTimes != nil {
+		return s.cache.stopIdToStopTimes
+	}
+
+	stopIdToStopTimes := make(map[string][]StopTime)
+	for _, stopTime := range s.StopTimes {
+		stopIdToStopTimes[stopTime.StopId] = append(stopIdToStopTimes[stopTime.StopId], stopTime)
+	}
+
+	s.cache.stopIdToStopTimes = stopIdToStopTimes
+	return stopIdToStopTimes
+}
+
+// GetTripIdToStopTimes maps each trip to its stop times ordered by stop sequence.
+func (s *Schedule) GetTripIdToStopTimes() map[string][]StopTime {
+	if s.cache.tripIdToStopTimes != nil {
+		return s.cache.tripIdToStopTimes
+	}
+
+	tripIdToStopTimes := make(map[string][]StopTime, len(s.Trips))
+	for _, stopTime := range s.StopTimes {
+		tripIdToStopTimes[stopTime.TripId] = append(tripIdToStopTimes[stopTime.TripId], stopTime)
+	}
+	for _, stopTimes := range tripIdToStopTimes {
+		slices.SortFunc(stopTimes, func(a, b StopTime) int {
+			return a.StopSequence - b.StopSequence
+		})
+	}
+
+	s.cache.tripIdToStopTimes = tripIdToStopTimes
+	return tripIdToStopTimes
+}
+
+// FindTrip returns the scheduled trip of a realtime trip ID running on the service day of startDate.
+// NYCT realtime trip IDs omit the service prefix of scheduled trip IDs,
+// e.g. "000600_1..S03R" is "ASP25GEN-1037-Weekday-00_000600_1..S03R" on weekdays.
+func (s *Schedule) FindTrip(realtimeTripId string, startDate time.Time) (Trip, bool) {
+	if trip, exists := s.GetTripIdToTrip()[realtimeTripId]; exists {
+		return trip, true
+	}
+
+	serviceIds := s.ActiveServiceIds(startDate)
+	for _, trip := range s.getRealtimeIdToTrips()[realtimeTripId] {
+		if _, active := serviceIds[trip.ServiceId]; active {
+			return trip, true
+		}
+	}
+	return Trip{}, false
+}
+
+// getRealtimeIdToTrips maps realtime trip IDs to the scheduled trips of every service they run on.
+func (s *Schedule) getRealtimeIdToTrips() map[string][]Trip {
+	if s.cache.realtimeIdToTrips != nil {
+		return s.cache.realtimeIdToTrips
+	}
+
+	realtimeIdToTrips := make(map[string][]Trip)
+	for _, trip := range s.Trips {
+		if _, realtimeId, found := strings.Cut(trip.TripId, "_"); found {
+			realtimeIdToTrips[realtimeId] = append(realtimeIdToTrips[realtimeId], trip)
+		}
+	}
+
+	s.cache.realtimeIdToTrips = realtimeIdToTrips
+	return realtimeIdToTrips
+}
+
+// buildCache derives every cached value up front. The schedule is only read from afterwards,
+// which makes it safe for concurrent use.
+func (s *Schedule) buildCache() {
+	s.GetStations()
+	s.GetStopIdToName()
+	s.GetTripIdToTrip()
+	s.GetTripIdToFinalStopId()
+	s.GetStopIdToStopTimes()
+	s.GetTripIdToStopTimes()
+	s.getRealtimeIdToTrips()
+	s.getStationGrid()
+}
+
+// GetSchedule returns a GTFS schedule containing all schedule files.
+// The schedule ZIP folder is cached in the data directory and conditionally revalidated,
+// so the last cached copy is used when the schedule is unchanged or cannot be fetched.
+// The download is abandoned once ctx is done, in which case the cached copy is not used.
+func GetSchedule(ctx context.Context) (*Schedule, error) {
+	zipPath, err := syncScheduleZip(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch schedule: %v", err)
 	}
 
+	return GetLocalSchedule(zipPath)
+}
+
+// NewScheduleFetcher returns a function fetching the schedule with [GetSchedule], except that its first call
+// returns the cached copy when there is one so that startup does not wait on the network.
+// A schedule read from the cache is due to be revalidated right away, see [Schedule.RefetchInterval].
+func NewScheduleFetcher() func(ctx context.Context) (*Schedule, error) {
+	var fetched atomic.Bool
+	return func(ctx context.Context) (*Schedule, error) {
+		if !fetched.Swap(true) {
+			if schedule, err := GetLocalSchedule(""); err == nil {
+				schedule.cache.fromCache = true
+				return schedule, nil
+			}
+		}
+		return GetSchedule(ctx)
+	}
+}
+
+// GetLocalSchedule reads a GTFS schedule from a local ZIP folder or directory without using the network.
+// An empty path reads the schedule cached by [GetSchedule].
+// The returned schedule has its caches built and is safe for concurrent use.
+func GetLocalSchedule(path string) (*Schedule, error) {
+	if path == "" {
+		path = dataDir + scheduleZipFile
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %v", err)
+	}
+	var schedule *Schedule
+	if info.IsDir() {
+		schedule, err = readScheduleDir(path)
+	} else {
+		schedule, err = readScheduleZip(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.buildCache()
+	return schedule, nil
+}
+
+func readScheduleZip(zipPath string) (*Schedule, error) {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open schedule %s: %v", zipPath, err)
+	}
+	defer zipReader.Close()
+
 	schedule := Schedule{}
-	for _, file := range scheduleFiles {
-		if err := parseScheduleFile(file, &schedule); err != nil {
+	for _, file := range zipReader.File {
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip file %s: %v", file.Name, err)
+		}
+		err = parseScheduleFile(file.Name, rc, &schedule)
+		rc.Close()
+		if err != nil {
 			return nil, fmt.Errorf("failed to parse schedule file %s: %v", file.Name, err)
 		}
 	}
@@ -169,87 +366,183 @@ func GetSchedule() (*Schedule, error) {
 	return &schedule, nil
 }
 
-// fetchScheduleFiles requests a GTFS schedule ZIP folder and returns its zip files.
-func fetchScheduleFiles() ([]*zip.File, error) {
-	// Download the ZIP folder
-	resp, err := http.Get(scheduleUrl)
+func readScheduleDir(dir string) (*Schedule, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download schedule from %s: %v", scheduleUrl, err)
+		return nil, fmt.Errorf("failed to read schedule directory %s: %v", dir, err)
 	}
-	defer resp.Body.Close()
 
-	// Read the ZIP data into memory
-	zipData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read ZIP data from response: %v", err)
+	schedule := Schedule{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open schedule file %s: %v", entry.Name(), err)
+		}
+		err = parseScheduleFile(entry.Name(), f, &schedule)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse schedule file %s: %v", entry.Name(), err)
+		}
+	}
+
+	return &schedule, nil
+}
+
+// scheduleValidators are the response headers used to revalidate the cached schedule.
+type scheduleValidators struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"lastModified"`
+}
+
+// syncScheduleZip ensures the cached schedule ZIP folder is up to date and returns its path.
+// If the schedule cannot be fetched, the cached copy is returned when one exists.
+func syncScheduleZip(ctx context.Context) (string, error) {
+	zipPath := dataDir + scheduleZipFile
+	hasCache := false
+	if zipReader, err := zip.OpenReader(zipPath); err == nil {
+		zipReader.Close()
+		hasCache = true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		// The validators describe a schedule we can no longer read, so download it again unconditionally
+		log.Printf("ignoring cached schedule: %v", err)
 	}
 
-	// Create a ZIP reader
-	zipReader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	validators := scheduleValidators{}
+	if hasCache {
+		validators = readScheduleValidators()
+	}
+
+	err := fetchScheduleZip(ctx, zipPath, validators)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
+	}
+	if err != nil && hasCache {
+		log.Printf("using cached schedule: %v", err)
+		return zipPath, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create ZIP reader: %v", err)
+		return "", err
 	}
 
-	return zipReader.File, nil
+	return zipPath, nil
 }
 
-func parseScheduleFile(file *zip.File, schedule *Schedule) error {
-	rc, err := file.Open()
+// fetchScheduleZip requests the GTFS schedule ZIP folder and stores it at zipPath.
+// The request is conditional on the given validators; nothing is written if the schedule is unchanged.
+func fetchScheduleZip(ctx context.Context, zipPath string, validators scheduleValidators) error {
+	ctx, cancel := context.WithTimeout(ctx, scheduleTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheduleUrl, nil)
 	if err != nil {
-		return fmt.Errorf("failed to open zip file %s: %v", file.Name, err)
+		return fmt.Errorf("failed to create request for %s: %v", scheduleUrl, err)
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
 	}
-	defer rc.Close()
 
-	// Map filename to schedule field and type
-	switch file.Name {
-	case "stops.txt":
-		var err error
-		schedule.Stops, err = csvutil.ReadAllParsed(rc, Stop{})
-		return err
-	case "stop_times.txt":
-		var err error
-		schedule.StopTimes, err = csvutil.ReadAllParsed(rc, StopTime{})
-		return err
-	case "trips.txt":
-		var err error
-		schedule.Trips, err = csvutil.ReadAllParsed(rc, Trip{})
-		return err
-	case "routes.txt":
-		var err error
-		schedule.Routes, err = csvutil.ReadAllParsed(rc, Route{})
-		return err
-	case "calendar.txt":
-		var err error
-		schedule.Calendars, err = csvutil.ReadAllParsed(rc, Calendar{})
-		return err
-	case "calendar_dates.txt":
-		var err error
-		schedule.CalendarDates, err = csvutil.ReadAllParsed(rc, CalendarDate{})
-		return err
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download schedule from %s: %v", scheduleUrl, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
 	default:
-		return nil // Skip unknown files
+		return fmt.Errorf("failed to download schedule from %s: %s", scheduleUrl, resp.Status)
+	}
+
+	// Ensure the data directory exists
+	if err := os.MkdirAll(dataDir, dirPerms); err != nil {
+		return fmt.Errorf("failed to create data directory %s: %v", dataDir, err)
 	}
-}
 
-func storeScheduleFile(file *zip.File) {
-	rc, err := file.Open()
+	// Write to a temporary file first so an interrupted download never replaces the cached copy
+	tmpFile, err := os.CreateTemp(dataDir, scheduleZipFile+".*.tmp")
 	if err != nil {
-		log.Panicf("failed to open zip file %s: %v", file.Name, err)
+		return fmt.Errorf("failed to create temporary schedule file: %v", err)
 	}
-	defer rc.Close()
+	defer os.Remove(tmpFile.Name())
 
-	data, err := io.ReadAll(rc)
+	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to read ZIP data from response: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary schedule file: %v", err)
+	}
+	// A truncated download or a captive portal's page must not replace a good cached copy
+	zipReader, err := zip.OpenReader(tmpFile.Name())
 	if err != nil {
-		log.Panicf("failed to read data from zip file %s: %v", file.Name, err)
+		return fmt.Errorf("downloaded schedule is not a valid ZIP folder: %v", err)
+	}
+	zipReader.Close()
+	if err := os.Chmod(tmpFile.Name(), filePerms); err != nil {
+		return fmt.Errorf("failed to set permissions on schedule file: %v", err)
+	}
+	if err := os.Rename(tmpFile.Name(), zipPath); err != nil {
+		return fmt.Errorf("failed to store schedule at %s: %v", zipPath, err)
 	}
 
-	// Ensure the data directory exists
-	if err := os.MkdirAll(dataDir, dirPerms); err != nil {
-		log.Panicf("failed to create data directory %s: %v", dataDir, err)
+	writeScheduleValidators(scheduleValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+	return nil
+}
+
+// readScheduleValidators returns the stored validators of the cached schedule.
+// Missing or invalid validators are treated as empty, which forces a full download.
+func readScheduleValidators() scheduleValidators {
+	validators := scheduleValidators{}
+	data, err := os.ReadFile(dataDir + scheduleValidatorsFile)
+	if err != nil {
+		return validators
+	}
+	if err := json.Unmarshal(data, &validators); err != nil {
+		log.Printf("ignoring invalid schedule validators: %v", err)
 	}
+	return validators
+}
 
-	err = os.WriteFile(dataDir+file.Name, data, filePerms)
+func writeScheduleValidators(validators scheduleValidators) {
+	data, err := json.Marshal(validators)
 	if err != nil {
-		log.Panicf("failed to write file %s: %v", dataDir+file.Name, err)
+		log.Printf("failed to encode schedule validators: %v", err)
+		return
+	}
+	if err := os.WriteFile(dataDir+scheduleValidatorsFile, data, filePerms); err != nil {
+		log.Printf("failed to write schedule validators: %v", err)
+	}
+}
+
+func parseScheduleFile(name string, r io.Reader, schedule *Schedule) error {
+	// Map filename to schedule field and type
+	var err error
+	switch name {
+	case "stops.txt":
+		schedule.Stops, err = csvutil.ReadAllParsed(r, Stop{})
+	case "stop_times.txt":
+		schedule.StopTimes, err = csvutil.ReadAllParsed(r, StopTime{})
+	case "trips.txt":
+		schedule.Trips, err = csvutil.ReadAllParsed(r, Trip{})
+	case "routes.txt":
+		schedule.Routes, err = csvutil.ReadAllParsed(r, Route{})
+	case "calendar.txt":
+		schedule.Calendars, err = csvutil.ReadAllParsed(r, Calendar{})
+	case "calendar_dates.txt":
+		schedule.CalendarDates, err = csvutil.ReadAllParsed(r, CalendarDate{})
+	default:
+		return nil // Skip unknown files
 	}
+	return err
 }
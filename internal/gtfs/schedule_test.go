@@ -0,0 +1,86 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with -race to check that a schedule with its caches built is only read from.
+func TestScheduleConcurrentReads(t *testing.T) {
+	schedule := weekendSchedule()
+	schedule.buildCache()
+
+	from := time.Date(2026, 3, 7, 6, 0, 0, 0, Location)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			FindScheduleDepartures([]string{"L01N"}, schedule, from, from.Add(4*time.Hour))
+			FindTripDetail("SAT_1", nil, schedule, from)
+			schedule.FindTrip("1", from)
+			schedule.FindNearbyStations(Coordinates{}, 1)
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkParseStopTimes(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type\n")
+	for trip := range 5000 {
+		for stop := range 100 {
+			at := fmt.Sprintf("%02d:%02d:00", (trip/60)%24, stop%60)
+			fmt.Fprintf(&sb, "ASP25GEN-1037-Weekday-00_%06d_L..N,%s,%s,L%02dN,%d,0,0\n", trip, at, at, stop, stop+1)
+		}
+	}
+	data := sb.String()
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		schedule := Schedule{}
+		if err := parseScheduleFile("stop_times.txt", strings.NewReader(data), &schedule); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestScheduleFetcherStartsFromCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(dataDir, dirPerms); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	w, err := zipWriter.Create("stops.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "stop_id,stop_name,location_type\nL01,8 Av,1\n")
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataDir+scheduleZipFile, buf.Bytes(), filePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first call must not touch the network, which a cancelled context would fail
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	schedule, err := NewScheduleFetcher()(ctx)
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	if len(schedule.Stops) != 1 || !schedule.cache.fromCache {
+		t.Errorf("got stops %v from cache %v, want the cached stop", schedule.Stops, schedule.cache.fromCache)
+	}
+}
@@ -0,0 +1,334 @@
+package gtfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/pb"
+)
+
+const mtaFeedUrl = "https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/"
+
+// feedTimeout limits a single request for a realtime feed, so a hung connection fails the feed instead of blocking the poll.
+const feedTimeout = 10 * time.Second
+
+// PollTimeout limits a whole poll of the realtime feeds. It is longer than a single request may take,
+// so that a hung feed fails on its own instead of abandoning the poll of every feed.
+const PollTimeout = feedTimeout + 5*time.Second
+
+// FeedPresets maps preset names to the realtime feed URLs of a transit system.
+var FeedPresets = map[string][]string{
+	"nyct": {
+		mtaFeedUrl + "nyct%2Fgtfs-ace",
+		mtaFeedUrl + "nyct%2Fgtfs-bdfm",
+		mtaFeedUrl + "nyct%2Fgtfs-g",
+		mtaFeedUrl + "nyct%2Fgtfs-jz",
+		mtaFeedUrl + "nyct%2Fgtfs-nqrw",
+		mtaFeedUrl + "nyct%2Fgtfs-l",
+		mtaFeedUrl + "nyct%2Fgtfs",
+		mtaFeedUrl + "nyct%2Fgtfs-si",
+		mtaFeedUrl + "camsys%2Fsubway-alerts",
+	},
+	"lirr": {
+		mtaFeedUrl + "lirr%2Fgtfs-lirr",
+	},
+	"mnr": {
+		mtaFeedUrl + "mnr%2Fgtfs-mnr",
+	},
+}
+
+// feedNameToRouteIds maps NYCT subway feeds to the routes they carry,
+// so a feed can be matched to stations before any message has been fetched from it.
+var feedNameToRouteIds = map[string][]string{
+	"gtfs-ace":  {"A", "C", "E", "H", "FS"},
+	"gtfs-bdfm": {"B", "D", "F", "FX", "M"},
+	"gtfs-g":    {"G"},
+	"gtfs-jz":   {"J", "Z"},
+	"gtfs-nqrw": {"N", "Q", "R", "W"},
+	"gtfs-l":    {"L"},
+	"gtfs":      {"1", "2", "3", "4", "5", "6", "6X", "7", "7X", "GS"},
+	"gtfs-si":   {"SI"},
+}
+
+// alertFeedNames are the feeds that only carry service alerts, without any trip updates.
+var alertFeedNames = map[string]struct{}{
+	"subway-alerts": {},
+}
+
+// FeedSource provides snapshots of a single GTFS realtime feed.
+type FeedSource interface {
+	// Name identifies the feed, e.g. "gtfs-ace". Recorded snapshots are stored under this name.
+	Name() string
+	// Fetch returns the current snapshot of the feed, giving up once ctx is done.
+	Fetch(ctx context.Context) (Snapshot, error)
+}
+
+// Snapshot is a single message of a feed as it was fetched.
+type Snapshot struct {
+	Msg  *pb.FeedMessage
+	Data []byte // Protobuf encoding of Msg as fetched, nil if Msg was decoded from another format
+}
+
+// HTTPSource fetches a feed from a URL.
+type HTTPSource struct {
+	URL string
+}
+
+func (s HTTPSource) Name() string {
+	return feedName(s.URL)
+}
+
+func (s HTTPSource) Fetch(ctx context.Context) (Snapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, feedTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Snapshot{}, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	msg := &pb.FeedMessage{}
+	if err := proto.Unmarshal(body, msg); err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{Msg: msg, Data: body}, nil
+}
+
+// FileSource reads a feed from a single snapshot file: raw protobuf (.pb), JSON (.json)
+// or an archive segment (.pb.gz), in which case its newest message is used.
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Name() string {
+	name := filepath.Base(s.Path)
+	for _, ext := range []string{segmentExt, ".pb", ".json"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+func (s FileSource) Fetch(ctx context.Context) (Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, err
+	}
+	if strings.HasSuffix(s.Path, segmentExt) {
+		snapshot, _, err := readLastSegmentMessage(s.Path)
+		return snapshot, err
+	}
+	return readSnapshotFile(s.Path)
+}
+
+// DirSource reads the newest snapshot from a directory of recorded snapshots,
+// such as a feed directory written by [RecordRealtime].
+type DirSource struct {
+	Dir string
+}
+
+func (s DirSource) Name() string {
+	return filepath.Base(s.Dir)
+}
+
+func (s DirSource) Fetch(ctx context.Context) (Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, err
+	}
+	snapshot, err := readNewestSnapshot(s.Dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if snapshot.Msg == nil {
+		return Snapshot{}, fmt.Errorf("no snapshots found in %s", s.Dir)
+	}
+	return snapshot, nil
+}
+
+// MemorySource serves a fixed feed message or error, e.g. as a test fixture.
+type MemorySource struct {
+	FeedName string
+	Msg      *pb.FeedMessage
+	Err      error
+}
+
+func (s MemorySource) Name() string {
+	return s.FeedName
+}
+
+func (s MemorySource) Fetch(ctx context.Context) (Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, err
+	}
+	if s.Err != nil {
+		return Snapshot{}, s.Err
+	}
+	return Snapshot{Msg: s.Msg}, nil
+}
+
+// ParseFeedSources builds feed sources from a comma separated list.
+// Each item is a preset name from [FeedPresets], an HTTP(S) URL, a snapshot directory or a snapshot file.
+func ParseFeedSources(spec string) ([]FeedSource, error) {
+	sources := []FeedSource{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if feedUrls, exists := FeedPresets[item]; exists {
+			for _, feedUrl := range feedUrls {
+				sources = append(sources, HTTPSource{URL: feedUrl})
+			}
+			continue
+		}
+		if strings.HasPrefix(item, "http://") || strings.HasPrefix(item, "https://") {
+			sources = append(sources, HTTPSource{URL: item})
+			continue
+		}
+
+		info, err := os.Stat(item)
+		if err != nil {
+			return nil, fmt.Errorf("unknown feed source %q: %v", item, err)
+		}
+		if info.IsDir() {
+			sources = append(sources, DirSource{Dir: item})
+		} else {
+			sources = append(sources, FileSource{Path: item})
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no feed sources given")
+	}
+	return sources, nil
+}
+
+// RecordedFeedSources returns a [DirSource] for every feed recorded in dir.
+// An empty dir reads the recorded feeds directory.
+func RecordedFeedSources(dir string) ([]FeedSource, error) {
+	if dir == "" {
+		dir = feedsDir
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recorded feeds: %v", err)
+	}
+
+	sources := []FeedSource{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			sources = append(sources, DirSource{Dir: filepath.Join(dir, entry.Name())})
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no recorded feeds found in %s", dir)
+	}
+	return sources, nil
+}
+
+// readNewestSnapshot decodes the newest snapshot in dir, either a single snapshot file
+// or the last message of the newest archive segment. The snapshot's Msg is nil if dir does not contain any snapshots.
+func readNewestSnapshot(dir string) (Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	newestName := ""
+	var newestTimestamp uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || (ext != ".pb" && ext != ".json") {
+			continue
+		}
+		timestamp, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue // Not a snapshot
+		}
+		if newestName == "" || timestamp > newestTimestamp {
+			newestName, newestTimestamp = name, timestamp
+		}
+	}
+
+	newest := Snapshot{}
+	if newestName != "" {
+		newest, err = readSnapshotFile(filepath.Join(dir, newestName))
+		if err != nil {
+			return Snapshot{}, err
+		}
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(segments) > 0 {
+		last, _, err := readLastSegmentMessage(segments[len(segments)-1])
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if newest.Msg == nil || last.Msg.GetHeader().GetTimestamp() > newest.Msg.GetHeader().GetTimestamp() {
+			newest = last
+		}
+	}
+
+	return newest, nil
+}
+
+// readSnapshotFile decodes a single snapshot stored as JSON (.json) or raw protobuf.
+func readSnapshotFile(snapshotPath string) (Snapshot, error) {
+	data, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	msg := &pb.FeedMessage{}
+	if filepath.Ext(snapshotPath) == ".json" {
+		if err := protojson.Unmarshal(data, msg); err != nil {
+			return Snapshot{}, fmt.Errorf("failed to decode %s: %v", snapshotPath, err)
+		}
+		return Snapshot{Msg: msg}, nil
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode %s: %v", snapshotPath, err)
+	}
+	return Snapshot{Msg: msg, Data: data}, nil
+}
+
+// feedName returns a short name for the feed at feedUrl, e.g. "gtfs-ace".
+func feedName(feedUrl string) string {
+	name, err := url.PathUnescape(path.Base(feedUrl))
+	if err != nil {
+		name = path.Base(feedUrl)
+	}
+	return path.Base(name)
+}
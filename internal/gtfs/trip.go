@@ -0,0 +1,144 @@
+package gtfs
+
+import (
+	"time"
+
+	"nyct-feed/internal/pb"
+)
+
+// TripDetail is the progress of a single trip, as predicted by the realtime feeds or else as scheduled.
+type TripDetail struct {
+	TripId        string
+	TrainId       string // NYCT train ID, empty if unknown
+	RouteId       string
+	Direction     string // NYCT direction of travel, e.g. "NORTH", empty if unknown
+	Unassigned    bool   // The trip has not been assigned a train yet and may not run
+	FinalStopId   string
+	FinalStopName string
+	Stops         []TripStop // Remaining stops in travel order
+}
+
+// TripStop is a remaining stop of a trip.
+type TripStop struct {
+	StopId    string
+	StopName  string
+	Scheduled time.Time     // Scheduled arrival, zero if the trip could not be matched to the schedule
+	Predicted time.Time     // Predicted arrival, zero if the trip has no prediction for the stop
+	Delay     time.Duration // Predicted minus scheduled, zero if either is unknown
+	Skipped   bool
+	Track     Track // Zero if the feed does not provide tracks
+}
+
+// FindTripDetail returns the remaining stops of the trip with the given ID as of now.
+// A trip that is not in any of the realtime feeds is looked up in the schedule instead,
+// e.g. for a departure only known from the schedule.
+// Reports false if the trip is in neither, or has completed.
+func FindTripDetail(tripId string, realtime []*pb.FeedMessage, schedule *Schedule, now time.Time) (TripDetail, bool) {
+	for _, feedMsg := range realtime {
+		for _, feedEntity := range feedMsg.GetEntity() {
+			tripUpdate := feedEntity.GetTripUpdate()
+			if tripUpdate.GetTrip().GetTripId() == tripId && len(tripUpdate.GetStopTimeUpdate()) > 0 {
+				return newTripDetail(tripUpdate, schedule), true
+			}
+		}
+	}
+	return findScheduledTripDetail(tripId, schedule, now)
+}
+
+// findScheduledTripDetail returns the stops of a scheduled trip that are still ahead as of now,
+// on the earliest service day the trip runs and has not completed by now.
+func findScheduledTripDetail(tripId string, schedule *Schedule, now time.Time) (TripDetail, bool) {
+	trip, exists := schedule.GetTripIdToTrip()[tripId]
+	stopTimes := schedule.GetTripIdToStopTimes()[tripId]
+	if !exists || len(stopTimes) == 0 {
+		return TripDetail{}, false
+	}
+	stopIdToName := schedule.GetStopIdToName()
+	finalStopId := stopTimes[len(stopTimes)-1].StopId
+
+	// Trips of the previous service day may run past midnight
+	nowDate := now.In(Location)
+	for day := -1; day <= 1; day++ {
+		date := time.Date(nowDate.Year(), nowDate.Month(), nowDate.Day()+day, 0, 0, 0, 0, Location)
+		if _, active := schedule.ActiveServiceIds(date)[trip.ServiceId]; !active {
+			continue
+		}
+
+		serviceDay := ServiceDay(date)
+		detail := TripDetail{
+			TripId:        trip.TripId,
+			RouteId:       trip.RouteId,
+			FinalStopId:   finalStopId,
+			FinalStopName: stopIdToName[finalStopId],
+		}
+		for _, stopTime := range stopTimes {
+			scheduled, err := ParseTime(serviceDay, stopTime.ArrivalTime)
+			if err != nil || scheduled.Before(now) {
+				continue
+			}
+			detail.Stops = append(detail.Stops, TripStop{
+				StopId:    stopTime.StopId,
+				StopName:  stopIdToName[stopTime.StopId],
+				Scheduled: scheduled,
+			})
+		}
+		if len(detail.Stops) > 0 {
+			return detail, true
+		}
+	}
+	return TripDetail{}, false
+}
+
+func newTripDetail(tripUpdate *pb.TripUpdate, schedule *Schedule) TripDetail {
+	trip := tripUpdate.GetTrip()
+	stopTimes := tripUpdate.GetStopTimeUpdate()
+	stopIdToName := schedule.GetStopIdToName()
+
+	// Scheduled arrivals are looked up by stop since realtime updates only cover remaining stops
+	stopIdToScheduled := map[string]time.Time{}
+	if scheduledStopTimes, serviceDay, ok := schedule.findScheduledStopTimes(trip); ok {
+		for _, stopTime := range scheduledStopTimes {
+			if scheduled, err := ParseTime(serviceDay, stopTime.ArrivalTime); err == nil {
+				stopIdToScheduled[stopTime.StopId] = scheduled
+			}
+		}
+	}
+
+	finalStopId := stopTimes[len(stopTimes)-1].GetStopId()
+	nyctTrip := getNyctTrip(trip)
+	detail := TripDetail{
+		TripId:        trip.GetTripId(),
+		TrainId:       nyctTrip.GetTrainId(),
+		RouteId:       trip.GetRouteId(),
+		Unassigned:    isUnassigned(nyctTrip),
+		FinalStopId:   finalStopId,
+		FinalStopName: stopIdToName[finalStopId],
+	}
+	if nyctTrip.GetDirection() != 0 {
+		detail.Direction = nyctTrip.GetDirection().String()
+	}
+
+	for _, stopTime := range stopTimes {
+		predicted := stopTime.GetArrival().GetTime()
+		if predicted == 0 {
+			predicted = stopTime.GetDeparture().GetTime()
+		}
+
+		stop := TripStop{
+			StopId:    stopTime.GetStopId(),
+			StopName:  stopIdToName[stopTime.GetStopId()],
+			Scheduled: stopIdToScheduled[stopTime.GetStopId()],
+			Skipped:   stopTime.GetScheduleRelationship() == pb.TripUpdate_StopTimeUpdate_SKIPPED,
+			Track:     getTrack(stopTime),
+		}
+		if predicted != 0 {
+			stop.Predicted = time.Unix(predicted, 0)
+		}
+		if !stop.Predicted.IsZero() && !stop.Scheduled.IsZero() {
+			stop.Delay = stop.Predicted.Sub(stop.Scheduled)
+		}
+		detail.Stops = append(detail.Stops, stop)
+	}
+
+	return detail
+}
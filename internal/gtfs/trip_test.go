@@ -0,0 +1,30 @@
+package gtfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindTripDetailFallsBackToSchedule(t *testing.T) {
+	schedule := weekendSchedule()
+	now := time.Date(2026, 3, 7, 8, 2, 0, 0, Location) // Saturday, between the trip's two stops
+
+	detail, found := FindTripDetail("SAT_1", nil, schedule, now)
+	if !found {
+		t.Fatal("scheduled trip not found")
+	}
+	if detail.RouteId != "L" || detail.FinalStopId != "L03N" {
+		t.Errorf("got route %s to %s, want L to L03N", detail.RouteId, detail.FinalStopId)
+	}
+	want := time.Date(2026, 3, 7, 8, 5, 0, 0, Location)
+	if len(detail.Stops) != 1 || detail.Stops[0].StopId != "L03N" || !detail.Stops[0].Scheduled.Equal(want) {
+		t.Errorf("got stops %v, want L03N at %v", detail.Stops, want)
+	}
+
+	if _, found := FindTripDetail("SAT_1", nil, schedule, now.Add(time.Hour)); found {
+		t.Error("completed trip found")
+	}
+	if _, found := FindTripDetail("SUN_1", nil, schedule, now.AddDate(0, 0, -1)); found {
+		t.Error("trip not running around Friday found")
+	}
+}
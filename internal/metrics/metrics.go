@@ -0,0 +1,119 @@
+// Package metrics writes metrics in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds suited to network fetches.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Histogram counts observations in cumulative buckets, partitioned by the value of a single label.
+type Histogram struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries // Keyed by label value
+}
+
+type histogramSeries struct {
+	counts []uint64 // Observations in each bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name string, help string, label string, buckets []float64) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+}
+
+// Observe records value for the series with the given label value.
+func (h *Histogram) Observe(labelValue string, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, exists := h.series[labelValue]
+	if !exists {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = series
+	}
+	if i, _ := slices.BinarySearch(h.buckets, value); i < len(h.buckets) {
+		series.counts[i]++
+	}
+	series.sum += value
+	series.count++
+}
+
+// Write writes every series of the histogram.
+func (h *Histogram) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, labelValue := range slices.Sorted(maps.Keys(h.series)) {
+		series := h.series[labelValue]
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += series.counts[i]
+			le := strconv.FormatFloat(upperBound, 'g', -1, 64)
+			fmt.Fprintf(w, "%s_bucket{%s=%s,le=%q} %d\n", h.name, h.label, quote(labelValue), le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%s,le=\"+Inf\"} %d\n", h.name, h.label, quote(labelValue), series.count)
+		fmt.Fprintf(w, "%s_sum{%s=%s} %s\n", h.name, h.label, quote(labelValue), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count{%s=%s} %d\n", h.name, h.label, quote(labelValue), series.count)
+	}
+}
+
+// WriteGauge writes a gauge with a series for each label value.
+func WriteGauge(w io.Writer, name string, help string, label string, values map[string]float64) {
+	writeSeries(w, name, help, "gauge", label, values)
+}
+
+// WriteCounter writes a counter with a series for each label value.
+func WriteCounter(w io.Writer, name string, help string, label string, values map[string]float64) {
+	writeSeries(w, name, help, "counter", label, values)
+}
+
+func writeSeries(w io.Writer, name string, help string, metricType string, label string, values map[string]float64) {
+	writeHeader(w, name, help, metricType)
+	for _, labelValue := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(w, "%s{%s=%s} %s\n", name, label, quote(labelValue), formatValue(values[labelValue]))
+	}
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// quote escapes a label value as required by the text format.
+func quote(labelValue string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labelValue) + `"`
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
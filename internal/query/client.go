@@ -0,0 +1,414 @@
+package query
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultGcTime is how long a query without subscribers is kept in the cache when its options do not say.
+const DefaultGcTime = 5 * time.Minute
+
+// Client caches queries by key, so that any number of subscribers share a single query and its fetches.
+// Every fetch is cancelled and every subscription stops once the client's context is done.
+type Client struct {
+	ctx     context.Context
+	mu      sync.Mutex // Guards entries, and is taken before the mutex of an entry
+	entries map[string]entry
+	focused atomic.Bool
+}
+
+// entry is a cached query of any data type.
+type entry interface {
+	refetch()
+	invalidate()
+	focusChanged(focused bool)
+	unused(gcGen int) bool
+}
+
+func NewClient(ctx context.Context) *Client {
+	c := &Client{ctx: ctx, entries: map[string]entry{}}
+	c.focused.Store(true)
+	return c
+}
+
+// Focused reports whether the user is paying attention to the data, which is assumed until told otherwise.
+func (c *Client) Focused() bool {
+	return c.focused.Load()
+}
+
+// SetFocused records whether the user is paying attention to the data, e.g. whether the terminal has focus.
+// Refetch intervals are recomputed, and queries with stale data are refetched when focus returns.
+func (c *Client) SetFocused(focused bool) {
+	if c.focused.Swap(focused) == focused {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		e.focusChanged(focused)
+	}
+}
+
+// Subscribe returns a channel receiving the state of the query with the given key, starting with its
+// current state, and a function to unsubscribe. The query is created with options if it is not cached,
+// and fetched if its data is stale. Updates are not buffered: a subscriber that falls behind only
+// receives the latest state.
+func Subscribe[TData any](c *Client, key string, options QueryOptions[TData]) (<-chan Query[TData], func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q := getEntry[TData](c, key)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.hasOptions {
+		q.options = options
+		q.hasOptions = true
+	}
+	q.gcGen++ // Keep the query cached
+
+	sub := &subscriber[TData]{
+		ch:      make(chan Query[TData]),
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go sub.forward(c.ctx)
+	q.subscribers[sub] = struct{}{}
+	sub.offer(q.state)
+
+	if q.isStale() {
+		q.fetch(false)
+	} else if len(q.subscribers) == 1 {
+		q.scheduleRefetch()
+	}
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() { q.unsubscribe(sub) })
+	}
+}
+
+// GetQuery returns the current state of the query with the given key, if it is cached.
+func GetQuery[TData any](c *Client, key string) (Query[TData], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists {
+		return Query[TData]{}, false
+	}
+	q := getEntry[TData](c, key)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.state, true
+}
+
+// SetData replaces the data of the query with the given key as if it had just been fetched,
+// creating the query if it is not cached. A fetch in flight is not cancelled.
+func SetData[TData any](c *Client, key string, data TData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q := getEntry[TData](c, key)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.state.Data = data
+	q.state.DataUpdatedAt = time.Now()
+	q.state.FetchedAt = q.state.DataUpdatedAt
+	q.state.Status = Success
+	q.state.Error = nil
+	q.invalidated = false
+	if len(q.subscribers) == 0 {
+		q.scheduleGc()
+	}
+	q.publish()
+}
+
+// Refetch fetches the query with the given key now, cancelling any fetch in flight.
+func (c *Client) Refetch(key string) {
+	c.mu.Lock()
+	e, exists := c.entries[key]
+	c.mu.Unlock()
+	if exists {
+		e.refetch()
+	}
+}
+
+// Invalidate marks the data of the query with the given key as stale. A query with subscribers
+// is refetched now; otherwise it is fetched by its next subscriber.
+func (c *Client) Invalidate(key string) {
+	c.mu.Lock()
+	e, exists := c.entries[key]
+	c.mu.Unlock()
+	if exists {
+		e.invalidate()
+	}
+}
+
+// getEntry returns the cached query with the given key, creating it if needed. Must be called with mu held.
+func getEntry[TData any](c *Client, key string) *cachedQuery[TData] {
+	e, exists := c.entries[key]
+	if !exists {
+		q := &cachedQuery[TData]{client: c, key: key, subscribers: map[*subscriber[TData]]struct{}{}}
+		c.entries[key] = q
+		return q
+	}
+	q, ok := e.(*cachedQuery[TData])
+	if !ok {
+		log.Panicf("Query %q is cached with a different data type", key)
+	}
+	return q
+}
+
+// remove drops q from the cache if it is still unused once its gc timer fires.
+func (c *Client) remove(e entry, key string, gcGen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] == e && e.unused(gcGen) {
+		delete(c.entries, key)
+	}
+}
+
+// cachedQuery is a query in a client's cache along with its subscribers.
+type cachedQuery[TData any] struct {
+	client *Client
+	key    string
+
+	mu          sync.Mutex
+	options     QueryOptions[TData]
+	hasOptions  bool // Whether a subscriber has given options; a query created by SetData cannot fetch until then
+	state       Query[TData]
+	invalidated bool // Whether the data is stale regardless of StaleTime
+	subscribers map[*subscriber[TData]]struct{}
+	fetchId     int                // Identifies the current fetch, so superseded results can be discarded
+	cancelFetch context.CancelFunc // Cancels the fetch in flight, nil if there is none
+	// Timers are never stopped; a timer whose generation is out of date does nothing when it fires
+	refetchGen int
+	gcGen      int
+}
+
+func (q *cachedQuery[TData]) refetch() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.fetch(true)
+}
+
+func (q *cachedQuery[TData]) focusChanged(focused bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.subscribers) == 0 {
+		return
+	}
+	if focused && q.isStale() {
+		q.fetch(false)
+		return
+	}
+	q.scheduleRefetch()
+}
+
+func (q *cachedQuery[TData]) invalidate() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.invalidated = true
+	if len(q.subscribers) > 0 {
+		q.fetch(true)
+	}
+}
+
+// isStale reports whether the data should be fetched for a new subscriber. Must be called with mu held.
+func (q *cachedQuery[TData]) isStale() bool {
+	return q.state.DataUpdatedAt.IsZero() || q.invalidated || time.Since(q.state.DataUpdatedAt) >= q.options.StaleTime
+}
+
+// fetch starts fetching the data. A fetch in flight is cancelled if cancelInFlight is set,
+// otherwise it is left to finish in place of a new one. Must be called with mu held.
+func (q *cachedQuery[TData]) fetch(cancelInFlight bool) {
+	if !q.hasOptions {
+		return
+	}
+	if q.cancelFetch != nil {
+		if !cancelInFlight {
+			return
+		}
+		q.cancelFetch()
+	}
+
+	q.fetchId++
+	q.state.FetchedAt = time.Now()
+	q.state.FetchStatus = Fetching
+	q.state.FailureCount = 0
+	if q.state.DataUpdatedAt.IsZero() {
+		q.state.Status = Pending
+	}
+	q.publish()
+
+	q.cancelFetch = executeQuery(q.client.ctx, q.fetchId, q.options, q.onResult)
+	q.scheduleRefetch()
+}
+
+// onResult applies the result of an invocation of QueryFn.
+func (q *cachedQuery[TData]) onResult(result fetchResult[TData]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if result.id != q.fetchId {
+		return
+	}
+
+	q.state.FetchDuration = result.duration
+	if result.err != nil {
+		q.state.FailureCount++
+	}
+	if result.retrying {
+		q.publish()
+		return
+	}
+
+	q.cancelFetch()
+	q.cancelFetch = nil
+	if result.err != nil {
+		q.state.Status = Error
+		q.state.Error = result.err
+		q.state.ErrorUpdatedAt = time.Now()
+	} else {
+		q.state.Status = Success
+		q.state.DataUpdatedAt = time.Now()
+		q.state.Data = result.data
+		q.state.Error = nil
+		q.state.FailureCount = 0
+		q.invalidated = false
+	}
+	q.state.FetchStatus = Idle
+	q.publish()
+	q.scheduleRefetch() // The interval may depend on the result
+}
+
+// scheduleRefetch refetches the data once the refetch interval has passed since the most recent fetch started,
+// while the query has subscribers, replacing any refetch scheduled before. A fetch still in flight is only
+// superseded if it has no timeout. Must be called with mu held.
+func (q *cachedQuery[TData]) scheduleRefetch() {
+	q.refetchGen++
+	interval := q.options.RefetchInterval
+	if q.options.RefetchIntervalFn != nil {
+		interval = q.options.RefetchIntervalFn(q.state)
+	}
+	if interval <= 0 || len(q.subscribers) == 0 {
+		return
+	}
+
+	from := q.state.FetchedAt
+	if from.IsZero() {
+		from = time.Now()
+	}
+	refetchGen := q.refetchGen
+	time.AfterFunc(time.Until(from.Add(interval)), func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if refetchGen != q.refetchGen || q.client.ctx.Err() != nil {
+			return
+		}
+		if q.cancelFetch != nil && (q.options.Timeout > 0 || q.state.FailureCount > 0) {
+			// Let the fetch finish within its timeout and the retries run their course,
+			// the refetch is rescheduled once they end
+			return
+		}
+		q.fetch(true)
+	})
+}
+
+// scheduleGc removes the query from the cache after GcTime unless it is subscribed to again. Must be called with mu held.
+func (q *cachedQuery[TData]) scheduleGc() {
+	q.gcGen++
+	gcTime := q.options.GcTime
+	if gcTime <= 0 {
+		gcTime = DefaultGcTime
+	}
+
+	gcGen := q.gcGen
+	time.AfterFunc(gcTime, func() {
+		q.client.remove(q, q.key, gcGen)
+	})
+}
+
+// unused reports whether the query has had no subscribers since its gc timer of the given generation was started.
+func (q *cachedQuery[TData]) unused(gcGen int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if gcGen != q.gcGen || len(q.subscribers) > 0 {
+		return false
+	}
+	if q.cancelFetch != nil {
+		q.cancelFetch()
+		q.cancelFetch = nil
+	}
+	return true
+}
+
+func (q *cachedQuery[TData]) unsubscribe(sub *subscriber[TData]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.subscribers, sub)
+	close(sub.done)
+	if len(q.subscribers) == 0 {
+		q.refetchGen++ // Stop refetching
+		q.scheduleGc()
+	}
+}
+
+// publish offers the current state to every subscriber. Must be called with mu held.
+func (q *cachedQuery[TData]) publish() {
+	for sub := range q.subscribers {
+		sub.offer(q.state)
+	}
+}
+
+// subscriber forwards the latest state of a query to its channel.
+type subscriber[TData any] struct {
+	ch      chan Query[TData]
+	mu      sync.Mutex
+	latest  Query[TData]
+	unsent  bool          // Whether latest has not been sent yet
+	pending chan struct{} // Signaled when latest is offered
+	done    chan struct{} // Closed on unsubscribe
+}
+
+// offer replaces the state waiting to be sent.
+func (s *subscriber[TData]) offer(q Query[TData]) {
+	s.mu.Lock()
+	s.latest = q
+	s.unsent = true
+	s.mu.Unlock()
+	select {
+	case s.pending <- struct{}{}:
+	default:
+	}
+}
+
+// forward sends offered states until the subscriber unsubscribes or ctx is done.
+func (s *subscriber[TData]) forward(ctx context.Context) {
+	for {
+		select {
+		case <-s.pending:
+		case <-s.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		s.mu.Lock()
+		q, unsent := s.latest, s.unsent
+		s.unsent = false
+		s.mu.Unlock()
+		if !unsent {
+			continue // Already sent when signaled before
+		}
+
+		select {
+		case s.ch <- q:
+		case <-s.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+// next returns the first state received from ch that satisfies done.
+func next[TData any](t *testing.T, ch <-chan Query[TData], done func(Query[TData]) bool) Query[TData] {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case q := <-ch:
+			if done(q) {
+				return q
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for query state")
+		}
+	}
+}
+
+func settled[TData any](q Query[TData]) bool {
+	return q.FetchStatus == Idle && q.Status != Pending
+}
+
+// counter returns a QueryFn that counts its invocations and returns the count.
+func counter(calls *atomic.Int32) func(context.Context) (int, error) {
+	return func(context.Context) (int, error) {
+		return int(calls.Add(1)), nil
+	}
+}
+
+func newTestClient(t *testing.T) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewClient(ctx)
+}
+
+func TestSubscribersShareFetch(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	options := QueryOptions[int]{
+		QueryFn: func(ctx context.Context) (int, error) {
+			calls.Add(1)
+			<-release
+			return 1, nil
+		},
+		StaleTime: time.Minute,
+	}
+
+	first, unsubscribeFirst := Subscribe(c, "key", options)
+	defer unsubscribeFirst()
+	second, unsubscribeSecond := Subscribe(c, "key", options)
+	defer unsubscribeSecond()
+	close(release)
+
+	for _, ch := range []<-chan Query[int]{first, second} {
+		if q := next(t, ch, settled); q.Data != 1 {
+			t.Errorf("got data %d, want 1", q.Data)
+		}
+	}
+
+	// Fresh data is not fetched again for a new subscriber
+	third, unsubscribeThird := Subscribe(c, "key", options)
+	defer unsubscribeThird()
+	next(t, third, settled)
+	if got := calls.Load(); got != 1 {
+		t.Errorf("QueryFn called %d times, want 1", got)
+	}
+}
+
+func TestRefetchCancelsFetchInFlight(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	options := QueryOptions[int]{
+		QueryFn: func(ctx context.Context) (int, error) {
+			if calls.Add(1) == 1 {
+				close(started)
+				<-ctx.Done()
+				close(cancelled)
+				return 0, ctx.Err()
+			}
+			return 2, nil
+		},
+	}
+
+	ch, unsubscribe := Subscribe(c, "key", options)
+	defer unsubscribe()
+	<-started
+	c.Refetch("key")
+
+	select {
+	case <-cancelled:
+	case <-time.After(testTimeout):
+		t.Fatal("fetch in flight was not cancelled")
+	}
+	if q := next(t, ch, settled); q.Status != Success || q.Data != 2 {
+		t.Errorf("got %v with data %d, want Success with 2", q.Status, q.Data)
+	}
+}
+
+func TestIntervalRefetchWaitsForFetchWithTimeout(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	firstErr := make(chan error, 1)
+	options := QueryOptions[int]{
+		QueryFn: func(ctx context.Context) (int, error) {
+			if calls.Add(1) > 1 {
+				return 2, nil
+			}
+			// Longer than the refetch interval, within the timeout
+			select {
+			case <-time.After(50 * time.Millisecond):
+				firstErr <- nil
+				return 1, nil
+			case <-ctx.Done():
+				firstErr <- ctx.Err()
+				return 0, ctx.Err()
+			}
+		},
+		RefetchInterval: 10 * time.Millisecond,
+		Timeout:         time.Second,
+	}
+
+	_, unsubscribe := Subscribe(c, "key", options)
+	defer unsubscribe()
+	select {
+	case err := <-firstErr:
+		if err != nil {
+			t.Errorf("slow fetch failed: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("slow fetch did not finish")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	options := QueryOptions[int]{QueryFn: counter(&calls), StaleTime: time.Hour}
+
+	ch, unsubscribe := Subscribe(c, "key", options)
+	next(t, ch, settled)
+
+	// A query with subscribers is refetched right away
+	c.Invalidate("key")
+	if q := next(t, ch, func(q Query[int]) bool { return settled(q) && q.Data == 2 }); q.Status != Success {
+		t.Errorf("got %v, want Success", q.Status)
+	}
+
+	// Without subscribers it is fetched by the next one, although its data is not older than StaleTime
+	unsubscribe()
+	c.Invalidate("key")
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("QueryFn called %d times without subscribers, want 2", got)
+	}
+	ch, unsubscribe = Subscribe(c, "key", options)
+	defer unsubscribe()
+	next(t, ch, func(q Query[int]) bool { return settled(q) && q.Data == 3 })
+}
+
+func TestGcRemovesUnusedQueries(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	options := QueryOptions[int]{QueryFn: counter(&calls), GcTime: 20 * time.Millisecond}
+
+	ch, unsubscribe := Subscribe(c, "key", options)
+	next(t, ch, settled)
+	unsubscribe()
+	if _, cached := GetQuery[int](c, "key"); !cached {
+		t.Fatal("query removed before GcTime")
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		if _, cached := GetQuery[int](c, "key"); !cached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("unused query was not removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGcKeepsResubscribedQueries(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	options := QueryOptions[int]{QueryFn: counter(&calls), StaleTime: time.Hour, GcTime: 20 * time.Millisecond}
+
+	ch, unsubscribe := Subscribe(c, "key", options)
+	next(t, ch, settled)
+	unsubscribe()
+	ch, unsubscribe = Subscribe(c, "key", options)
+	defer unsubscribe()
+	next(t, ch, settled)
+
+	time.Sleep(50 * time.Millisecond)
+	if q, cached := GetQuery[int](c, "key"); !cached || q.Data != 1 {
+		t.Errorf("GetQuery = %d, %v; want the query with subscribers kept", q.Data, cached)
+	}
+}
+
+func TestSlowSubscriberReceivesLatestState(t *testing.T) {
+	c := newTestClient(t)
+	SetData(c, "key", 0)
+	ch, unsubscribe := Subscribe(c, "key", QueryOptions[int]{
+		QueryFn:   func(context.Context) (int, error) { return 0, errors.New("unused") },
+		StaleTime: time.Hour,
+	})
+	defer unsubscribe()
+	next(t, ch, func(Query[int]) bool { return true })
+
+	// Updates made while the subscriber is not reading are coalesced: at most the one
+	// already being sent arrives before the latest
+	for data := 1; data <= 10; data++ {
+		SetData(c, "key", data)
+	}
+	received := []int{}
+	next(t, ch, func(q Query[int]) bool {
+		received = append(received, q.Data)
+		return q.Data == 10
+	})
+	if len(received) > 2 {
+		t.Errorf("received %v, want the latest state only", received)
+	}
+	select {
+	case q := <-ch:
+		t.Errorf("got data %d after the latest state, want nothing", q.Data)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSubscribeWithNeverStaleFetchesOnce(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	options := QueryOptions[int]{QueryFn: counter(&calls), StaleTime: NeverStale}
+
+	ch, unsubscribe := Subscribe(c, "key", options)
+	defer unsubscribe()
+	next(t, ch, settled)
+	for range 3 {
+		ch, unsubscribe := Subscribe(c, "key", options)
+		next(t, ch, settled)
+		unsubscribe()
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("QueryFn called %d times, want 1", got)
+	}
+}
+
+func TestRefetchIntervalMeasuredFromFetchStart(t *testing.T) {
+	c := newTestClient(t)
+	var calls atomic.Int32
+	refetched := make(chan time.Time, 1)
+	options := QueryOptions[int]{
+		QueryFn: func(context.Context) (int, error) {
+			if calls.Add(1) == 2 {
+				refetched <- time.Now()
+			}
+			time.Sleep(40 * time.Millisecond)
+			return 1, nil
+		},
+		// Recomputed once the fetch finishes, which must not push the refetch back by the fetch's duration
+		RefetchIntervalFn: func(q Query[int]) time.Duration {
+			if q.FetchedAt.IsZero() {
+				t.Error("FetchedAt is not set")
+			}
+			return 60 * time.Millisecond
+		},
+	}
+
+	ch, unsubscribe := Subscribe(c, "key", options)
+	defer unsubscribe()
+	fetchedAt := next(t, ch, settled).FetchedAt
+	select {
+	case at := <-refetched:
+		if elapsed := at.Sub(fetchedAt); elapsed < 60*time.Millisecond || elapsed >= 100*time.Millisecond {
+			t.Errorf("refetched %v after the fetch started, want 60ms", elapsed)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("query was not refetched")
+	}
+}
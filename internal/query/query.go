@@ -1,7 +1,10 @@
 package query
 
 import (
+	"context"
 	"log"
+	"math"
+	"math/rand/v2"
 	"time"
 )
 
@@ -22,7 +25,7 @@ func (s Status) String() string {
 	case Success:
 		return "Success"
 	default:
-		log.Panicf("Unknown Status: %v", s)
+		log.Panicf("Unknown Status: %d", int(s))
 		return "Unknown"
 	}
 }
@@ -41,7 +44,7 @@ func (fs FetchStatus) String() string {
 	case Idle:
 		return "Idle"
 	default:
-		log.Panicf("Unknown FetchStatus: %v", fs)
+		log.Panicf("Unknown FetchStatus: %d", int(fs))
 		return "Unknown"
 	}
 }
@@ -49,59 +52,110 @@ func (fs FetchStatus) String() string {
 type Query[TData any] struct {
 	Data          TData
 	DataUpdatedAt time.Time
+	FetchedAt     time.Time     // When the most recent fetch started, which refetch intervals are measured from
+	FetchDuration time.Duration // How long the most recent completed invocation of QueryFn took
 	Status        Status
 	FetchStatus   FetchStatus
+	// Error of the most recent fetch once every retry has failed, nil if it succeeded
+	Error          error
+	ErrorUpdatedAt time.Time // When Error was last set
+	// FailureCount is the number of failed invocations of QueryFn in the current or most recent fetch,
+	// reset when a fetch starts or succeeds
+	FailureCount int
 }
 
+// QueryOptions configures a query. Queries shared through a [Client] use the options of their first subscriber.
 type QueryOptions[TData any] struct {
-	QueryChannel    chan Query[TData]
-	QueryFn         func() (TData, error)
+	// QueryFn fetches the data. ctx is cancelled when the client stops, when the invocation
+	// times out or when a refetch supersedes it.
+	QueryFn func(ctx context.Context) (TData, error)
+	// RefetchInterval is how often the data is refetched while the query has subscribers, zero to never refetch.
 	RefetchInterval time.Duration
+	// RefetchIntervalFn replaces RefetchInterval with an interval computed from the query's state whenever
+	// a fetch starts or finishes, or the client's focus changes. The interval is measured from q.FetchedAt,
+	// not from when it is computed. Returning zero pauses refetching until then.
+	RefetchIntervalFn func(q Query[TData]) time.Duration
+	// StaleTime is how long data stays fresh after it was fetched. A new subscriber only triggers a fetch once the data is stale.
+	StaleTime time.Duration
+	// GcTime is how long a query without subscribers is kept in the cache. Defaults to [DefaultGcTime].
+	GcTime time.Duration
+	// Timeout limits each invocation of QueryFn, zero for no limit.
+	Timeout time.Duration
+	// Retry is the number of times a failed invocation of QueryFn is retried before the fetch fails.
+	Retry int
+	// RetryDelay returns how long to wait before the next retry, given the number of failures so far.
+	// Defaults to [DefaultRetryDelay].
+	RetryDelay func(failureCount int) time.Duration
 }
 
-func CreateQuery[TData any](options QueryOptions[TData]) chan struct{} {
-	var q = Query[TData]{}
-	ticker := time.NewTicker(options.RefetchInterval)
-	quit := make(chan struct{})
+// NeverStale is a StaleTime for data kept fresh by its refetch interval alone:
+// once fetched, new subscribers never trigger a fetch of their own.
+const NeverStale = time.Duration(math.MaxInt64)
 
-	go func() {
-		defer ticker.Stop()
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
 
-		// Execute immediately
-		executeQuery(&q, options)
+// DefaultRetryDelay doubles the delay after every failure, starting at one second and capped at 30 seconds.
+// Jitter picks a random delay in the upper half of that range so clients failing together do not retry in lockstep.
+func DefaultRetryDelay(failureCount int) time.Duration {
+	delay := retryMaxDelay
+	if failureCount < 6 {
+		delay = min(retryBaseDelay<<max(failureCount-1, 0), retryMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// fetchResult is the outcome of a single invocation of QueryFn.
+type fetchResult[TData any] struct {
+	id       int // Identifies the invocation, so superseded results can be discarded
+	data     TData
+	err      error
+	duration time.Duration
+	retrying bool // Whether QueryFn is invoked again after this failure
+}
+
+// executeQuery invokes QueryFn in the background, retrying failures with backoff, and passes the
+// result of every invocation to deliver. The returned function cancels the fetch; its results are then dropped.
+func executeQuery[TData any](ctx context.Context, id int, options QueryOptions[TData], deliver func(fetchResult[TData])) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	retryDelay := options.RetryDelay
+	if retryDelay == nil {
+		retryDelay = DefaultRetryDelay
+	}
 
-		for {
+	go func() {
+		for failureCount := 0; ; failureCount++ {
+			start := time.Now()
+			data, err := invoke(ctx, options)
+			if ctx.Err() != nil {
+				return
+			}
+			retrying := err != nil && failureCount < options.Retry
+			deliver(fetchResult[TData]{id, data, err, time.Since(start), retrying})
+			if !retrying {
+				return
+			}
+
+			timer := time.NewTimer(retryDelay(failureCount + 1))
 			select {
-			case <-ticker.C:
-				executeQuery(&q, options)
-			case <-quit:
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
 				return
 			}
 		}
 	}()
-
-	return quit // Return quit channel so caller can stop the query
+	return cancel
 }
 
-func executeQuery[TData any](q *Query[TData], options QueryOptions[TData]) {
-	// Send update before invoking queryFn
-	q.FetchStatus = Fetching
-	if q.DataUpdatedAt.IsZero() {
-		q.Status = Pending
-	}
-	options.QueryChannel <- *q
-
-	// Invoke queryFn
-	data, err := options.QueryFn()
-
-	// Send resulting update after invoking queryFn
-	q.FetchStatus = Idle
-	if err != nil {
-		q.Status = Error
-	} else {
-		q.Status = Success
-		q.DataUpdatedAt = time.Now()
-		q.Data = data
+// invoke calls QueryFn, limited by the query's timeout.
+func invoke[TData any](ctx context.Context, options QueryOptions[TData]) (TData, error) {
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
 	}
-	options.QueryChannel <- *q
+	return options.QueryFn(ctx)
 }
@@ -0,0 +1,179 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/pb"
+	"nyct-feed/internal/query"
+)
+
+// Speeds are the playback speeds that a replay cycles through.
+var Speeds = []float64{1, 10, 60}
+
+// Clock is a simulated clock that advances at a multiple of real time.
+type Clock struct {
+	mu        sync.Mutex
+	simStart  time.Time
+	realStart time.Time
+	speed     float64
+}
+
+func NewClock(start time.Time, speed float64) *Clock {
+	return &Clock{
+		simStart:  start,
+		realStart: time.Now(),
+		speed:     speed,
+	}
+}
+
+// Now returns the current simulated time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now()
+}
+
+func (c *Clock) Speed() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.speed
+}
+
+// SetSpeed changes the playback speed without jumping the simulated time.
+func (c *Clock) SetSpeed(speed float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.simStart = c.now()
+	c.realStart = time.Now()
+	c.speed = speed
+}
+
+func (c *Clock) now() time.Time {
+	elapsed := float64(time.Since(c.realStart)) * c.speed
+	return c.simStart.Add(time.Duration(elapsed))
+}
+
+// frame is a single recorded feed message at its header timestamp.
+// The message is kept encoded, which takes a fraction of the memory of a decoded one, until it is played.
+type frame struct {
+	feedName  string
+	timestamp time.Time
+	data      []byte
+}
+
+// Replay plays back recorded feed messages in timestamp order against a simulated clock.
+type Replay struct {
+	frames []frame
+	clock  *Clock
+	done   chan struct{}
+}
+
+// Load reads the archived feeds in dir recorded between from and to.
+// A zero from or to leaves that end of the window unbounded.
+func Load(dir string, from, to time.Time, speed float64) (*Replay, error) {
+	frames := []frame{}
+	err := gtfs.ReadArchiveWindow(dir, from, to, func(feedName string, timestamp time.Time, data []byte) error {
+		frames = append(frames, frame{feedName, timestamp, data})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load replay: %v", err)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no recorded feeds found in the replay window")
+	}
+
+	slices.SortStableFunc(frames, func(a, b frame) int {
+		return a.timestamp.Compare(b.timestamp)
+	})
+
+	return &Replay{
+		frames: frames,
+		clock:  NewClock(frames[0].timestamp, speed),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Clock returns the simulated clock that the replay is played against.
+func (r *Replay) Clock() *Clock {
+	return r.clock
+}
+
+// Done is closed once every frame has been played.
+func (r *Replay) Done() <-chan struct{} {
+	return r.done
+}
+
+// CycleSpeed switches to the next faster (delta > 0) or slower (delta < 0) speed in Speeds.
+func (r *Replay) CycleSpeed(delta int) {
+	i := slices.Index(Speeds, r.clock.Speed())
+	i = min(max(i+delta, 0), len(Speeds)-1)
+	r.clock.SetSpeed(Speeds[i])
+}
+
+// Start plays the replay, sending the newest message of every feed to realtimeChannel
+// whenever the simulated clock passes a recorded frame, until every frame has been played or ctx is done.
+func (r *Replay) Start(ctx context.Context, realtimeChannel chan<- query.Query[*gtfs.Realtime]) {
+	go func() {
+		defer close(r.done)
+
+		feedNameToState := map[string]gtfs.FeedState{}
+		feedNames := []string{}
+		next := 0
+
+		for next < len(r.frames) {
+			// Wait in short steps so speed changes take effect promptly
+			if wait := r.frames[next].timestamp.Sub(r.clock.Now()); wait > 0 {
+				select {
+				case <-time.After(min(time.Duration(float64(wait)/r.clock.Speed()), 250*time.Millisecond)):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			// Apply every frame that the clock has passed
+			now := r.clock.Now()
+			for next < len(r.frames) && !r.frames[next].timestamp.After(now) {
+				f := r.frames[next]
+				next++
+				msg := &pb.FeedMessage{}
+				if err := proto.Unmarshal(f.data, msg); err != nil {
+					continue // Only the header was checked when loading; skip a frame that is otherwise corrupt
+				}
+				if _, exists := feedNameToState[f.feedName]; !exists {
+					feedNames = append(feedNames, f.feedName)
+					slices.Sort(feedNames)
+				}
+				feedNameToState[f.feedName] = gtfs.FeedState{
+					Name:      f.feedName,
+					Msg:       msg,
+					UpdatedAt: f.timestamp,
+				}
+			}
+
+			realtime := &gtfs.Realtime{Feeds: make([]gtfs.FeedState, len(feedNames))}
+			for i, feedName := range feedNames {
+				realtime.Feeds[i] = feedNameToState[feedName]
+			}
+
+			select {
+			case realtimeChannel <- query.Query[*gtfs.Realtime]{
+				Data:          realtime,
+				DataUpdatedAt: now,
+				Status:        query.Success,
+				FetchStatus:   query.Idle,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
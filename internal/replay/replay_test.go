@@ -0,0 +1,76 @@
+package replay
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/pb"
+)
+
+// writeArchive records a message for every timestamp of every feed, one segment per message.
+func writeArchive(t *testing.T, feedNameToTimestamps map[string][]uint64) string {
+	t.Helper()
+	dir := t.TempDir()
+	archive := gtfs.NewArchive(dir, 1)
+	for feedName, timestamps := range feedNameToTimestamps {
+		for _, timestamp := range timestamps {
+			msg := &pb.FeedMessage{Header: &pb.FeedHeader{
+				GtfsRealtimeVersion: proto.String("2.0"),
+				Timestamp:           proto.Uint64(timestamp),
+			}}
+			if _, err := archive.Append(feedName, gtfs.Snapshot{Msg: msg}); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+		}
+	}
+	return dir
+}
+
+func frameTimestamps(r *Replay) []int64 {
+	timestamps := []int64{}
+	for _, f := range r.frames {
+		timestamps = append(timestamps, f.timestamp.Unix())
+	}
+	return timestamps
+}
+
+func TestLoadOrdersFramesAcrossFeeds(t *testing.T) {
+	dir := writeArchive(t, map[string][]uint64{
+		"gtfs-ace": {100, 300, 500},
+		"gtfs-l":   {200, 400},
+	})
+
+	r, err := Load(dir, time.Time{}, time.Time{}, 1)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := frameTimestamps(r), []int64{100, 200, 300, 400, 500}; !slices.Equal(got, want) {
+		t.Errorf("frames at %v, want %v", got, want)
+	}
+	if got := r.Clock().Now().Unix(); got < 100 || got > 101 {
+		t.Errorf("clock starts at %d, want the first frame at 100", got)
+	}
+}
+
+func TestLoadWindow(t *testing.T) {
+	dir := writeArchive(t, map[string][]uint64{
+		"gtfs-ace": {100, 300, 500},
+		"gtfs-l":   {200, 400},
+	})
+
+	r, err := Load(dir, time.Unix(200, 0), time.Unix(400, 0), 1)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := frameTimestamps(r), []int64{200, 300, 400}; !slices.Equal(got, want) {
+		t.Errorf("frames at %v, want %v", got, want)
+	}
+
+	if _, err := Load(dir, time.Unix(600, 0), time.Time{}, 1); err == nil {
+		t.Error("Load of an empty window succeeded, want an error")
+	}
+}
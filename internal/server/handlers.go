@@ -0,0 +1,455 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/pb"
+)
+
+type stationJSON struct {
+	StationId string   `json:"station_id"`
+	Name      string   `json:"name"`
+	Routes    []string `json:"routes"`
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+}
+
+type routeJSON struct {
+	RouteId   string `json:"route_id"`
+	ShortName string `json:"short_name"`
+	LongName  string `json:"long_name"`
+	Color     string `json:"color"`
+	TextColor string `json:"text_color"`
+}
+
+type departuresJSON struct {
+	StationId     string          `json:"station_id"`
+	Name          string          `json:"name"`
+	Now           time.Time       `json:"now"`
+	UpdatedAt     *time.Time      `json:"updated_at"`               // When realtime data was last fetched, null if never
+	RealtimeError string          `json:"realtime_error,omitempty"` // Why the most recent realtime fetch failed, omitted if it succeeded
+	Departures    []departureJSON `json:"departures"`
+}
+
+type departureJSON struct {
+	RouteId       string              `json:"route_id"`
+	StopId        string              `json:"stop_id"`
+	Direction     string              `json:"direction"`
+	FinalStopId   string              `json:"final_stop_id"`
+	FinalStopName string              `json:"final_stop_name"`
+	Times         []departureTimeJSON `json:"times"`
+}
+
+type departureTimeJSON struct {
+	TripId       string     `json:"trip_id"`
+	TrainId      string     `json:"train_id,omitempty"`
+	Departs      time.Time  `json:"departs"`
+	Scheduled    *time.Time `json:"scheduled"`
+	Minutes      int        `json:"minutes"`
+	DelaySeconds int        `json:"delay_seconds"`
+	Source       string     `json:"source"`
+	Unassigned   bool       `json:"unassigned"`
+	Track        *trackJSON `json:"track,omitempty"`
+}
+
+type trackJSON struct {
+	Scheduled string `json:"scheduled,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+	Changed   bool   `json:"changed"`
+}
+
+type tripJSON struct {
+	TripId        string         `json:"trip_id"`
+	TrainId       string         `json:"train_id,omitempty"`
+	RouteId       string         `json:"route_id"`
+	Direction     string         `json:"direction,omitempty"`
+	Unassigned    bool           `json:"unassigned"`
+	FinalStopId   string         `json:"final_stop_id"`
+	FinalStopName string         `json:"final_stop_name"`
+	Stops         []tripStopJSON `json:"stops"`
+}
+
+type tripStopJSON struct {
+	StopId       string     `json:"stop_id"`
+	StopName     string     `json:"stop_name"`
+	Scheduled    *time.Time `json:"scheduled"`
+	Predicted    *time.Time `json:"predicted"`
+	DelaySeconds int        `json:"delay_seconds"`
+	Skipped      bool       `json:"skipped"`
+	Track        *trackJSON `json:"track,omitempty"`
+}
+
+type alertJSON struct {
+	AlertId     string   `json:"alert_id"`
+	Header      string   `json:"header"`
+	Description string   `json:"description"`
+	RouteIds    []string `json:"route_ids"`
+	StopIds     []string `json:"stop_ids"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+var (
+	errScheduleNotLoaded = errors.New("schedule is not loaded yet")
+	errUnknownStation    = errors.New("unknown station")
+	errUnknownRoute      = errors.New("unknown route")
+)
+
+// scheduleUnavailable explains why the schedule is not loaded, including why the last attempt to load it failed.
+func (s *Server) scheduleUnavailable() error {
+	if err := s.scheduleQuery().Error; err != nil {
+		return fmt.Errorf("%w: %v", errScheduleNotLoaded, err)
+	}
+	return errScheduleNotLoaded
+}
+
+// errorStatus returns the HTTP status of an error returned while handling a request.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errScheduleNotLoaded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errUnknownStation), errors.Is(err, errUnknownRoute):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleStations lists stations, optionally only those whose name contains the search parameter.
+func (s *Server) handleStations(w http.ResponseWriter, r *http.Request) {
+	schedule := s.scheduleQuery().Data
+	if schedule == nil {
+		err := s.scheduleUnavailable()
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	search := strings.ToLower(r.URL.Query().Get("search"))
+	stations := []stationJSON{}
+	for _, station := range schedule.GetStations() {
+		if strings.Contains(strings.ToLower(station.StopName), search) {
+			stations = append(stations, newStationJSON(station))
+		}
+	}
+	writeJSON(w, http.StatusOK, stations)
+}
+
+// handleDepartures lists the departures from a station, optionally only those of the
+// comma separated routes in the route parameter. The limit parameter caps the times per departure.
+func (s *Server) handleDepartures(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	routeIds := splitParam(r.URL.Query().Get("route"))
+
+	response, err := s.findDepartures(r.PathValue("id"), routeIds, limit)
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// findDepartures returns the departures from a station, optionally only those of the given routes,
+// including at most limit times per departure.
+func (s *Server) findDepartures(stationId string, routeIds []string, limit int) (departuresJSON, error) {
+	schedule := s.scheduleQuery().Data
+	if schedule == nil {
+		return departuresJSON{}, s.scheduleUnavailable()
+	}
+
+	stationIndex := slices.IndexFunc(schedule.GetStations(), func(station gtfs.Station) bool {
+		return station.StopId == stationId
+	})
+	if stationIndex == -1 {
+		return departuresJSON{}, fmt.Errorf("%w: %s", errUnknownStation, stationId)
+	}
+	station := schedule.GetStations()[stationIndex]
+
+	now := s.now()
+	stopIds := []string{station.StopId + "N", station.StopId + "S"}
+	response := departuresJSON{
+		StationId:  station.StopId,
+		Name:       station.StopName,
+		Now:        now,
+		UpdatedAt:  optionalTime(s.realtimeQuery().DataUpdatedAt),
+		Departures: []departureJSON{},
+	}
+	if err := s.realtimeQuery().Error; err != nil {
+		response.RealtimeError = err.Error()
+	}
+	for _, departure := range gtfs.FindDepartures(stopIds, s.realtimeMessages(), schedule, now) {
+		if len(routeIds) > 0 && !slices.Contains(routeIds, departure.RouteId) {
+			continue
+		}
+		if departureJSON := newDepartureJSON(departure, now, limit); len(departureJSON.Times) > 0 {
+			response.Departures = append(response.Departures, departureJSON)
+		}
+	}
+	return response, nil
+}
+
+// findRouteDepartures returns the departures of a route from every station it serves,
+// including at most limit times per departure.
+func (s *Server) findRouteDepartures(routeId string, limit int) (departuresJSON, error) {
+	schedule := s.scheduleQuery().Data
+	if schedule == nil {
+		return departuresJSON{}, s.scheduleUnavailable()
+	}
+	if !slices.ContainsFunc(schedule.Routes, func(route gtfs.Route) bool { return route.RouteId == routeId }) {
+		return departuresJSON{}, fmt.Errorf("%w: %s", errUnknownRoute, routeId)
+	}
+
+	stopIds := []string{}
+	for _, station := range schedule.GetStations() {
+		if slices.ContainsFunc(station.Routes, func(route gtfs.Route) bool { return route.RouteId == routeId }) {
+			stopIds = append(stopIds, station.StopId+"N", station.StopId+"S")
+		}
+	}
+
+	now := s.now()
+	response := departuresJSON{
+		Now:        now,
+		UpdatedAt:  optionalTime(s.realtimeQuery().DataUpdatedAt),
+		Departures: []departureJSON{},
+	}
+	if err := s.realtimeQuery().Error; err != nil {
+		response.RealtimeError = err.Error()
+	}
+	for _, departure := range gtfs.FindDepartures(stopIds, s.realtimeMessages(), schedule, now) {
+		if departure.RouteId != routeId {
+			continue
+		}
+		if departureJSON := newDepartureJSON(departure, now, limit); len(departureJSON.Times) > 0 {
+			response.Departures = append(response.Departures, departureJSON)
+		}
+	}
+	return response, nil
+}
+
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	schedule := s.scheduleQuery().Data
+	if schedule == nil {
+		err := s.scheduleUnavailable()
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	routes := []routeJSON{}
+	for _, route := range schedule.Routes {
+		routes = append(routes, routeJSON{
+			RouteId:   route.RouteId,
+			ShortName: route.RouteShortName,
+			LongName:  route.RouteLongName,
+			Color:     route.RouteColor,
+			TextColor: route.RouteTextColor,
+		})
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// handleTrip returns the remaining stops of a trip, predicted if it is in the realtime feeds and scheduled otherwise.
+func (s *Server) handleTrip(w http.ResponseWriter, r *http.Request) {
+	schedule := s.scheduleQuery().Data
+	if schedule == nil {
+		err := s.scheduleUnavailable()
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	tripId := r.PathValue("id")
+	trip, found := gtfs.FindTripDetail(tripId, s.realtimeMessages(), schedule, s.now())
+	if !found {
+		writeError(w, http.StatusNotFound, "trip is not running: "+tripId)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTripJSON(trip))
+}
+
+// handleAlerts lists active alerts, optionally only those informing the comma separated
+// stations in the station parameter or routes in the route parameter.
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	stationIds := splitParam(r.URL.Query().Get("station"))
+	routeIds := splitParam(r.URL.Query().Get("route"))
+
+	schedule := s.scheduleQuery().Data
+	if schedule == nil {
+		err := s.scheduleUnavailable()
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	// Without filters, alerts informing any station or route are listed
+	if len(stationIds) == 0 && len(routeIds) == 0 {
+		for _, station := range schedule.GetStations() {
+			stationIds = append(stationIds, station.StopId)
+		}
+		for _, route := range schedule.Routes {
+			routeIds = append(routeIds, route.RouteId)
+		}
+	}
+
+	alerts := []alertJSON{}
+	for _, alert := range gtfs.FindAlerts(stationIds, routeIds, s.realtimeMessages(), s.now()) {
+		alerts = append(alerts, alertJSON{
+			AlertId:     alert.AlertId,
+			Header:      alert.Header,
+			Description: alert.Description,
+			RouteIds:    alert.RouteIds,
+			StopIds:     alert.StopIds,
+		})
+	}
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+// realtimeMessages returns the latest realtime messages, nil if none have been fetched.
+func (s *Server) realtimeMessages() []*pb.FeedMessage {
+	realtime := s.realtimeQuery().Data
+	if realtime == nil {
+		return nil
+	}
+	return realtime.Messages()
+}
+
+func newStationJSON(station gtfs.Station) stationJSON {
+	routeIds := []string{}
+	for _, route := range station.Routes {
+		routeIds = append(routeIds, route.RouteId)
+	}
+	return stationJSON{
+		StationId: station.StopId,
+		Name:      station.StopName,
+		Routes:    routeIds,
+		Lat:       station.StopLat,
+		Lon:       station.StopLon,
+	}
+}
+
+// newDepartureJSON includes the (at most limit, or all if limit is 0) departure times that have not yet passed.
+func newDepartureJSON(departure gtfs.Departure, now time.Time, limit int) departureJSON {
+	times := []departureTimeJSON{}
+	for _, departureTime := range departure.Times {
+		if limit > 0 && len(times) == limit {
+			break
+		}
+		minutes := math.Round(departureTime.Time().Sub(now).Minutes())
+		if minutes < 0 {
+			continue
+		}
+		times = append(times, departureTimeJSON{
+			TripId:       departureTime.TripId,
+			TrainId:      departureTime.TrainId,
+			Departs:      departureTime.Time(),
+			Scheduled:    optionalTime(departureTime.Scheduled),
+			Minutes:      int(minutes),
+			DelaySeconds: int(departureTime.Delay.Seconds()),
+			Source:       departureTime.Source.String(),
+			Unassigned:   departureTime.Unassigned,
+			Track:        newTrackJSON(departureTime.Track),
+		})
+	}
+
+	return departureJSON{
+		RouteId:       departure.RouteId,
+		StopId:        departure.StopId,
+		Direction:     departure.StopId[len(departure.StopId)-1:],
+		FinalStopId:   departure.FinalStopId,
+		FinalStopName: departure.FinalStopName,
+		Times:         times,
+	}
+}
+
+func newTripJSON(trip gtfs.TripDetail) tripJSON {
+	stops := []tripStopJSON{}
+	for _, stop := range trip.Stops {
+		stops = append(stops, tripStopJSON{
+			StopId:       stop.StopId,
+			StopName:     stop.StopName,
+			Scheduled:    optionalTime(stop.Scheduled),
+			Predicted:    optionalTime(stop.Predicted),
+			DelaySeconds: int(stop.Delay.Seconds()),
+			Skipped:      stop.Skipped,
+			Track:        newTrackJSON(stop.Track),
+		})
+	}
+
+	return tripJSON{
+		TripId:        trip.TripId,
+		TrainId:       trip.TrainId,
+		RouteId:       trip.RouteId,
+		Direction:     trip.Direction,
+		Unassigned:    trip.Unassigned,
+		FinalStopId:   trip.FinalStopId,
+		FinalStopName: trip.FinalStopName,
+		Stops:         stops,
+	}
+}
+
+// newTrackJSON returns nil if the track is unknown so that it is omitted.
+func newTrackJSON(track gtfs.Track) *trackJSON {
+	if track.String() == "" {
+		return nil
+	}
+	return &trackJSON{
+		Scheduled: track.Scheduled,
+		Actual:    track.Actual,
+		Changed:   track.Changed(),
+	}
+}
+
+// optionalTime returns nil for the zero time so that it is encoded as null.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// parseLimit parses the limit parameter, which defaults to 3. A limit of 0 means no limit.
+func parseLimit(param string) (int, error) {
+	if param == "" {
+		return 3, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 0 {
+		return 0, errors.New("limit must be a non-negative integer")
+	}
+	return limit, nil
+}
+
+// splitParam splits a comma separated query parameter, ignoring empty items.
+func splitParam(param string) []string {
+	items := []string{}
+	for _, item := range strings.Split(param, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorJSON{Error: message})
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/pb"
+)
+
+// testNow is when the test feed was published, which offline servers serve data at: a Monday at 07:50.
+var testNow = time.Date(2026, 6, 1, 7, 50, 0, 0, gtfs.Location)
+
+// testScheduleFiles has an L train from 8 Av to Union Sq at 08:00 every day.
+var testScheduleFiles = map[string]string{
+	"stops.txt": `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station
+L01,8 Av,40.739,-74.002,1,
+L01N,8 Av,40.739,-74.002,0,L01
+L01S,8 Av,40.739,-74.002,0,L01
+L03,Union Sq - 14 St,40.735,-73.990,1,
+L03N,Union Sq - 14 St,40.735,-73.990,0,L03
+L03S,Union Sq - 14 St,40.735,-73.990,0,L03
+`,
+	"routes.txt": `route_id,agency_id,route_short_name,route_long_name,route_color,route_text_color
+L,MTA NYCT,L,14 St-Canarsie Local,A7A9AC,FFFFFF
+`,
+	"trips.txt": `route_id,trip_id,service_id,trip_headsign,direction_id
+L,DAILY_L1,DAILY,Union Sq,0
+`,
+	"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+DAILY_L1,08:00:00,08:00:00,L01N,1
+DAILY_L1,08:05:00,08:05:00,L03N,2
+`,
+	"calendar.txt": `service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+DAILY,1,1,1,1,1,1,1,20260101,20261231
+`,
+}
+
+// testFeedMessage has an alert for the L at Union Sq.
+func testFeedMessage() *pb.FeedMessage {
+	return &pb.FeedMessage{
+		Header: &pb.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(uint64(testNow.Unix())),
+		},
+		Entity: []*pb.FeedEntity{{
+			Id: proto.String("alert-1"),
+			Alert: &pb.Alert{
+				InformedEntity: []*pb.EntitySelector{{RouteId: proto.String("L"), StopId: proto.String("L03")}},
+				HeaderText: &pb.TranslatedString{Translation: []*pb.TranslatedString_Translation{
+					{Text: proto.String("Elevator out of service"), Language: proto.String("en")},
+				}},
+			},
+		}},
+	}
+}
+
+// newTestServer starts an offline server reading the schedule from schedulePath.
+func newTestServer(t *testing.T, schedulePath string) *Server {
+	t.Helper()
+	s := New(Options{
+		FeedSources:  []gtfs.FeedSource{gtfs.MemorySource{FeedName: "gtfs-l", Msg: testFeedMessage()}},
+		Offline:      true,
+		SchedulePath: schedulePath,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s.Start(ctx)
+	return s
+}
+
+// newLoadedServer starts a server with the test schedule and waits for its schedule and realtime data.
+func newLoadedServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range testScheduleFiles {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServer(t, dir)
+	waitFor(t, func() bool {
+		return s.scheduleQuery().Data != nil && s.realtimeQuery().Data != nil
+	})
+	return s
+}
+
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the server")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// get requests path from the server and decodes the JSON response into v.
+func get(t *testing.T, s *Server, path string, v any) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	s.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("GET %s: Content-Type = %q, want application/json", path, got)
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: failed to decode response: %v", path, err)
+	}
+	return recorder.Code
+}
+
+func TestHandleStations(t *testing.T) {
+	s := newLoadedServer(t)
+
+	var stations []stationJSON
+	if status := get(t, s, "/stations?search=union", &stations); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(stations) != 1 || stations[0].StationId != "L03" || len(stations[0].Routes) != 1 || stations[0].Routes[0] != "L" {
+		t.Errorf("stations = %+v, want Union Sq on the L", stations)
+	}
+
+	if get(t, s, "/stations", &stations); len(stations) != 2 {
+		t.Errorf("got %d stations without a search, want 2", len(stations))
+	}
+}
+
+func TestHandleDepartures(t *testing.T) {
+	s := newLoadedServer(t)
+
+	var departures departuresJSON
+	if status := get(t, s, "/stations/L01/departures?route=L", &departures); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if !departures.Now.Equal(testNow) {
+		t.Errorf("now = %v, want the feed timestamp %v", departures.Now, testNow)
+	}
+	if len(departures.Departures) != 1 || len(departures.Departures[0].Times) != 1 {
+		t.Fatalf("departures = %+v, want a single departure", departures.Departures)
+	}
+	departure := departures.Departures[0]
+	if departure.FinalStopId != "L03N" || departure.Direction != "N" || departure.Times[0].TripId != "DAILY_L1" || departure.Times[0].Minutes != 10 {
+		t.Errorf("departure = %+v, want DAILY_L1 to L03N in 10 minutes", departure)
+	}
+
+	if get(t, s, "/stations/L01/departures?route=A", &departures); len(departures.Departures) != 0 {
+		t.Errorf("departures of another route = %+v, want none", departures.Departures)
+	}
+
+	var errResponse errorJSON
+	if status := get(t, s, "/stations/X99/departures", &errResponse); status != http.StatusNotFound {
+		t.Errorf("status of an unknown station = %d, want 404", status)
+	}
+	if status := get(t, s, "/stations/L01/departures?limit=-1", &errResponse); status != http.StatusBadRequest {
+		t.Errorf("status of a negative limit = %d, want 400", status)
+	}
+}
+
+func TestHandlersBeforeScheduleLoads(t *testing.T) {
+	// Retrying takes seconds, so the schedule is still loading after its first failure
+	s := newTestServer(t, filepath.Join(t.TempDir(), "missing"))
+	waitFor(t, func() bool { return s.scheduleQuery().FailureCount > 0 })
+
+	for _, path := range []string{"/stations", "/stations/L01/departures", "/routes", "/trips/DAILY_L1", "/alerts"} {
+		var errResponse errorJSON
+		if status := get(t, s, path, &errResponse); status != http.StatusServiceUnavailable {
+			t.Errorf("GET %s: status = %d, want 503", path, status)
+		}
+		if !strings.Contains(errResponse.Error, "schedule is not loaded yet") {
+			t.Errorf("GET %s: error = %q, want that the schedule is not loaded", path, errResponse.Error)
+		}
+	}
+}
+
+func TestHandleRoutes(t *testing.T) {
+	s := newLoadedServer(t)
+
+	var routes []routeJSON
+	if status := get(t, s, "/routes", &routes); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	want := routeJSON{RouteId: "L", ShortName: "L", LongName: "14 St-Canarsie Local", Color: "A7A9AC", TextColor: "FFFFFF"}
+	if len(routes) != 1 || routes[0] != want {
+		t.Errorf("routes = %+v, want %+v", routes, want)
+	}
+}
+
+func TestHandleTrip(t *testing.T) {
+	s := newLoadedServer(t)
+
+	var trip tripJSON
+	if status := get(t, s, "/trips/DAILY_L1", &trip); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if trip.RouteId != "L" || trip.FinalStopId != "L03N" || len(trip.Stops) != 2 || trip.Stops[1].StopName != "Union Sq - 14 St" {
+		t.Errorf("trip = %+v, want the scheduled stops of the L to Union Sq", trip)
+	}
+
+	var errResponse errorJSON
+	if status := get(t, s, "/trips/NOPE", &errResponse); status != http.StatusNotFound {
+		t.Errorf("status of an unknown trip = %d, want 404", status)
+	}
+}
+
+func TestHandleAlerts(t *testing.T) {
+	s := newLoadedServer(t)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/alerts", 1},
+		{"/alerts?station=L03&route=L", 1},
+		{"/alerts?route=L", 0}, // Limited to a stop that is not requested
+		{"/alerts?station=L01", 0},
+	}
+	for _, test := range tests {
+		var alerts []alertJSON
+		if status := get(t, s, test.path, &alerts); status != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want 200", test.path, status)
+		}
+		if len(alerts) != test.want {
+			t.Errorf("GET %s: got %d alerts, want %d", test.path, len(alerts), test.want)
+		}
+		if len(alerts) > 0 && (alerts[0].AlertId != "alert-1" || alerts[0].Header != "Elevator out of service") {
+			t.Errorf("GET %s: alert = %+v, want alert-1", test.path, alerts[0])
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"nyct-feed/internal/metrics"
+	"nyct-feed/internal/query"
+)
+
+type serverMetrics struct {
+	feedFetchDuration  *metrics.Histogram
+	queryFetchDuration *metrics.Histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		feedFetchDuration: metrics.NewHistogram(
+			"nyct_feed_fetch_duration_seconds",
+			"Time taken to fetch a realtime feed, including failed fetches.",
+			"feed", metrics.DefaultBuckets,
+		),
+		queryFetchDuration: metrics.NewHistogram(
+			"nyct_query_fetch_duration_seconds",
+			"Time taken by a query to refetch its data.",
+			"query", metrics.DefaultBuckets,
+		),
+	}
+}
+
+// observeQuery records the duration of a finished query fetch.
+func (m *serverMetrics) observeQuery(name string, fetchStatus query.FetchStatus, fetchDuration time.Duration) {
+	if fetchStatus != query.Idle {
+		return
+	}
+	m.queryFetchDuration.Observe(name, fetchDuration.Seconds())
+}
+
+// observeFeedFetch records the duration of a single feed's fetch, called by the poller as each fetch ends.
+func (m *serverMetrics) observeFeedFetch(feedName string, duration time.Duration) {
+	m.feedFetchDuration.Observe(feedName, duration.Seconds())
+}
+
+// handleMetrics exports feed health and fetch latency in the Prometheus text format.
+// A feed whose nyct_feed_header_timestamp_seconds stops advancing is no longer being updated upstream.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	feeds := s.poller.Feeds()
+	now := time.Now()
+
+	fetches := map[string]float64{}
+	failures := map[string]float64{}
+	up := map[string]float64{}
+	payloadBytes := map[string]float64{}
+	entities := map[string]float64{}
+	headerTimestamps := map[string]float64{}
+	headerAges := map[string]float64{}
+	for _, feed := range feeds {
+		fetches[feed.Name] = float64(feed.Fetches)
+		failures[feed.Name] = float64(feed.Failures)
+		up[feed.Name] = 1
+		if feed.Degraded() || feed.Msg == nil {
+			up[feed.Name] = 0
+		}
+		if feed.Msg == nil {
+			continue
+		}
+		payloadBytes[feed.Name] = float64(feed.PayloadBytes)
+		entities[feed.Name] = float64(len(feed.Msg.GetEntity()))
+		headerTimestamp := feed.Msg.GetHeader().GetTimestamp()
+		headerTimestamps[feed.Name] = float64(headerTimestamp)
+		headerAges[feed.Name] = now.Sub(time.Unix(int64(headerTimestamp), 0)).Seconds()
+	}
+
+	queryFailures := map[string]float64{
+		scheduleKey: float64(s.scheduleQuery().FailureCount),
+		realtimeKey: float64(s.realtimeQuery().FailureCount),
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WriteCounter(w, "nyct_feed_fetches_total", "Number of realtime feed fetches, including failed fetches.", "feed", fetches)
+	metrics.WriteCounter(w, "nyct_feed_fetch_errors_total", "Number of failed realtime feed fetches.", "feed", failures)
+	metrics.WriteGauge(w, "nyct_feed_up", "Whether the most recent fetch of a realtime feed succeeded.", "feed", up)
+	metrics.WriteGauge(w, "nyct_feed_payload_bytes", "Encoded size of a realtime feed's last good message.", "feed", payloadBytes)
+	metrics.WriteGauge(w, "nyct_feed_entities", "Number of entities in a realtime feed's last good message.", "feed", entities)
+	metrics.WriteGauge(w, "nyct_feed_header_timestamp_seconds", "Header timestamp of a realtime feed's last good message.", "feed", headerTimestamps)
+	metrics.WriteGauge(w, "nyct_feed_header_age_seconds", "Seconds since the header timestamp of a realtime feed's last good message.", "feed", headerAges)
+	metrics.WriteGauge(w, "nyct_query_failures", "Number of failed attempts of a query's current or most recent fetch, including retries.", "query", queryFailures)
+	s.metrics.feedFetchDuration.Write(w)
+	s.metrics.queryFetchDuration.Write(w)
+}
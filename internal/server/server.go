@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/query"
+)
+
+// Options configures where the server gets its schedule and realtime data.
+type Options struct {
+	// FeedSources are polled for realtime data.
+	FeedSources []gtfs.FeedSource
+	// Offline reads the cached schedule instead of fetching it and serves realtime data relative to its timestamp.
+	Offline bool
+	// SchedulePath is a local GTFS schedule ZIP or directory read instead of fetching the schedule.
+	// An empty path reads the cached schedule when Offline is set.
+	SchedulePath string
+}
+
+// Query keys of the data shared by every client.
+const (
+	scheduleKey = "schedule"
+	realtimeKey = "realtime"
+)
+
+// Server serves schedule and realtime data as JSON, keeping it warm by polling in the background
+// so that any number of clients share a single poller.
+type Server struct {
+	options Options
+	poller  *gtfs.RealtimePoller
+	metrics *serverMetrics
+	client  *query.Client // Caches the schedule and realtime queries, created by Start
+}
+
+func New(options Options) *Server {
+	s := &Server{
+		options: options,
+		poller:  gtfs.NewRealtimePoller(options.FeedSources),
+		metrics: newServerMetrics(),
+	}
+	s.poller.ObserveFetch = s.metrics.observeFeedFetch
+	return s
+}
+
+// Start polls the schedule and realtime feeds until ctx is done. It must be called before serving.
+func (s *Server) Start(ctx context.Context) {
+	s.client = query.NewClient(ctx)
+	scheduleChannel, _ := query.Subscribe(s.client, scheduleKey, s.scheduleOptions())
+	realtimeChannel, _ := query.Subscribe(s.client, realtimeKey, s.realtimeOptions())
+
+	go func() {
+		for {
+			select {
+			case q := <-scheduleChannel:
+				s.metrics.observeQuery(scheduleKey, q.FetchStatus, q.FetchDuration)
+			case q := <-realtimeChannel:
+				s.metrics.observeQuery(realtimeKey, q.FetchStatus, q.FetchDuration)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Handler returns the HTTP handler serving the JSON API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stations", s.handleStations)
+	mux.HandleFunc("GET /stations/{id}/departures", s.handleDepartures)
+	mux.HandleFunc("GET /stations/{id}/departures/stream", s.handleDeparturesStream)
+	mux.HandleFunc("GET /routes", s.handleRoutes)
+	mux.HandleFunc("GET /routes/{id}/departures/stream", s.handleRouteDeparturesStream)
+	mux.HandleFunc("GET /trips/{id}", s.handleTrip)
+	mux.HandleFunc("GET /alerts", s.handleAlerts)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	return mux
+}
+
+func (s *Server) scheduleOptions() query.QueryOptions[*gtfs.Schedule] {
+	return query.QueryOptions[*gtfs.Schedule]{
+		QueryFn: s.scheduleFn(),
+		RefetchIntervalFn: func(q query.Query[*gtfs.Schedule]) time.Duration {
+			return q.Data.RefetchInterval(q.FetchedAt)
+		},
+		StaleTime: time.Hour,
+		Retry:     3,
+	}
+}
+
+func (s *Server) realtimeOptions() query.QueryOptions[*gtfs.Realtime] {
+	return query.QueryOptions[*gtfs.Realtime]{
+		QueryFn: s.poller.Poll,
+		RefetchIntervalFn: func(q query.Query[*gtfs.Realtime]) time.Duration {
+			return q.Data.PollInterval(q.FetchedAt)
+		},
+		// The subscription made by Start keeps the data fresh, so streams subscribing do not poll
+		StaleTime: query.NeverStale,
+		Timeout:   gtfs.PollTimeout,
+		Retry:     3,
+	}
+}
+
+// scheduleQuery returns the latest state of the schedule query.
+func (s *Server) scheduleQuery() query.Query[*gtfs.Schedule] {
+	q, _ := query.GetQuery[*gtfs.Schedule](s.client, scheduleKey)
+	return q
+}
+
+// realtimeQuery returns the latest state of the realtime query.
+func (s *Server) realtimeQuery() query.Query[*gtfs.Realtime] {
+	q, _ := query.GetQuery[*gtfs.Realtime](s.client, realtimeKey)
+	return q
+}
+
+func (s *Server) scheduleFn() func(context.Context) (*gtfs.Schedule, error) {
+	if s.options.Offline || s.options.SchedulePath != "" {
+		return func(context.Context) (*gtfs.Schedule, error) {
+			return gtfs.GetLocalSchedule(s.options.SchedulePath)
+		}
+	}
+	return gtfs.NewScheduleFetcher()
+}
+
+// now returns the time that the realtime data is served at.
+func (s *Server) now() time.Time {
+	if realtime := s.realtimeQuery().Data; s.options.Offline && realtime != nil {
+		return gtfs.FeedTimestamp(realtime.Messages())
+	}
+	return time.Now()
+}
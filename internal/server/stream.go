@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"nyct-feed/internal/query"
+)
+
+// keepAliveInterval is how often a comment is sent on idle streams so proxies do not close them.
+const keepAliveInterval = 30 * time.Second
+
+// streamDepartureJSON is a single departure time in a stream. Unlike departureTimeJSON it omits
+// the minutes until departure, which would change every minute without the departure changing.
+type streamDepartureJSON struct {
+	TripId        string     `json:"trip_id"`
+	TrainId       string     `json:"train_id,omitempty"`
+	RouteId       string     `json:"route_id"`
+	StopId        string     `json:"stop_id"`
+	Direction     string     `json:"direction"`
+	FinalStopName string     `json:"final_stop_name"`
+	Departs       time.Time  `json:"departs"`
+	DelaySeconds  int        `json:"delay_seconds"`
+	Source        string     `json:"source"`
+	Unassigned    bool       `json:"unassigned"`
+	Track         *trackJSON `json:"track,omitempty"`
+}
+
+// key identifies a departure across updates.
+func (d streamDepartureJSON) key() string {
+	return d.TripId + "|" + d.StopId
+}
+
+// equal reports whether two departures of the same trip are unchanged.
+func (d streamDepartureJSON) equal(other streamDepartureJSON) bool {
+	sameTrack := (d.Track == nil && other.Track == nil) ||
+		(d.Track != nil && other.Track != nil && *d.Track == *other.Track)
+	return d.Departs.Equal(other.Departs) &&
+		d.DelaySeconds == other.DelaySeconds &&
+		d.Source == other.Source &&
+		d.Unassigned == other.Unassigned &&
+		d.TrainId == other.TrainId &&
+		sameTrack
+}
+
+type snapshotEventJSON struct {
+	Now        time.Time             `json:"now"`
+	Departures []streamDepartureJSON `json:"departures"`
+}
+
+type diffEventJSON struct {
+	Now     time.Time             `json:"now"`
+	Added   []streamDepartureJSON `json:"added"`
+	Removed []streamDepartureJSON `json:"removed"`
+	Retimed []streamDepartureJSON `json:"retimed"` // Departures whose time, delay, status or track changed
+}
+
+func (d diffEventJSON) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Retimed) == 0
+}
+
+// handleDeparturesStream pushes a station's departures as Server-Sent Events.
+// Accepts the same route and limit parameters as handleDepartures.
+func (s *Server) handleDeparturesStream(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	routeIds := splitParam(r.URL.Query().Get("route"))
+	stationId := r.PathValue("id")
+
+	s.streamDepartures(w, r, func() (departuresJSON, error) {
+		return s.findDepartures(stationId, routeIds, limit)
+	})
+}
+
+// handleRouteDeparturesStream pushes the departures of a route from every station it serves as Server-Sent Events.
+// The limit parameter caps the times per departure.
+func (s *Server) handleRouteDeparturesStream(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	routeId := r.PathValue("id")
+
+	s.streamDepartures(w, r, func() (departuresJSON, error) {
+		return s.findRouteDepartures(routeId, limit)
+	})
+}
+
+// streamDepartures pushes the departures returned by find as Server-Sent Events. A "snapshot" event
+// with every departure is sent first, followed by a "diff" event whenever a poll changes them,
+// or an "error" event when they cannot be found after a poll.
+func (s *Server) streamDepartures(w http.ResponseWriter, r *http.Request, find func() (departuresJSON, error)) {
+	// Subscribe before the first snapshot so that no update is missed
+	updates, unsubscribe := query.Subscribe(s.client, realtimeKey, s.realtimeOptions())
+	defer unsubscribe()
+
+	response, err := find()
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	departures := newStreamDepartures(response)
+	if err := writeEvent(rc, w, "snapshot", snapshotEventJSON{Now: response.Now, Departures: departures}); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case q := <-updates:
+			if q.FetchStatus != query.Idle {
+				continue
+			}
+			response, err := find()
+			if err != nil {
+				if err := writeEvent(rc, w, "error", errorJSON{Error: err.Error()}); err != nil {
+					return
+				}
+				continue
+			}
+			next := newStreamDepartures(response)
+			diff := diffDepartures(departures, next)
+			departures = next
+			if diff.empty() {
+				continue
+			}
+			diff.Now = response.Now
+			if err := writeEvent(rc, w, "diff", diff); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// newStreamDepartures flattens departures into their individual departure times.
+func newStreamDepartures(response departuresJSON) []streamDepartureJSON {
+	departures := []streamDepartureJSON{}
+	for _, departure := range response.Departures {
+		for _, departureTime := range departure.Times {
+			departures = append(departures, streamDepartureJSON{
+				TripId:        departureTime.TripId,
+				TrainId:       departureTime.TrainId,
+				RouteId:       departure.RouteId,
+				StopId:        departure.StopId,
+				Direction:     departure.Direction,
+				FinalStopName: departure.FinalStopName,
+				Departs:       departureTime.Departs,
+				DelaySeconds:  departureTime.DelaySeconds,
+				Source:        departureTime.Source,
+				Unassigned:    departureTime.Unassigned,
+				Track:         departureTime.Track,
+			})
+		}
+	}
+	slices.SortFunc(departures, func(a, b streamDepartureJSON) int {
+		return a.Departs.Compare(b.Departs)
+	})
+	return departures
+}
+
+// diffDepartures returns the departures added, removed and retimed between prev and next.
+func diffDepartures(prev []streamDepartureJSON, next []streamDepartureJSON) diffEventJSON {
+	diff := diffEventJSON{
+		Added:   []streamDepartureJSON{},
+		Removed: []streamDepartureJSON{},
+		Retimed: []streamDepartureJSON{},
+	}
+
+	keyToPrev := map[string]streamDepartureJSON{}
+	for _, departure := range prev {
+		keyToPrev[departure.key()] = departure
+	}
+	keyToNext := map[string]streamDepartureJSON{}
+	for _, departure := range next {
+		keyToNext[departure.key()] = departure
+	}
+
+	for _, departure := range next {
+		prevDeparture, exists := keyToPrev[departure.key()]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, departure)
+		case !departure.equal(prevDeparture):
+			diff.Retimed = append(diff.Retimed, departure)
+		}
+	}
+	for _, departure := range prev {
+		if _, exists := keyToNext[departure.key()]; !exists {
+			diff.Removed = append(diff.Removed, departure)
+		}
+	}
+
+	return diff
+}
+
+// writeEvent writes a Server-Sent Event with v encoded as JSON and flushes it to the client.
+func writeEvent(rc *http.ResponseController, w http.ResponseWriter, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffDepartures(t *testing.T) {
+	departs := time.Date(2026, 6, 1, 8, 0, 0, 0, time.UTC)
+	departure := func(tripId string, delaySeconds int) streamDepartureJSON {
+		return streamDepartureJSON{
+			TripId:       tripId,
+			StopId:       "L01N",
+			Departs:      departs.Add(time.Duration(delaySeconds) * time.Second),
+			DelaySeconds: delaySeconds,
+			Source:       "Realtime",
+		}
+	}
+	retracked := departure("kept", 0)
+	retracked.Track = &trackJSON{Scheduled: "1", Actual: "2", Changed: true}
+	otherStop := departure("added", 0)
+	otherStop.StopId = "L01S"
+
+	tests := []struct {
+		name    string
+		prev    []streamDepartureJSON
+		next    []streamDepartureJSON
+		added   []string
+		removed []string
+		retimed []string
+	}{
+		{"unchanged", []streamDepartureJSON{departure("kept", 0)}, []streamDepartureJSON{departure("kept", 0)}, nil, nil, nil},
+		{"added", nil, []streamDepartureJSON{departure("added", 0)}, []string{"added"}, nil, nil},
+		{"removed", []streamDepartureJSON{departure("removed", 0)}, nil, nil, []string{"removed"}, nil},
+		{"delayed", []streamDepartureJSON{departure("kept", 0)}, []streamDepartureJSON{departure("kept", 60)}, nil, nil, []string{"kept"}},
+		{"track changed", []streamDepartureJSON{departure("kept", 0)}, []streamDepartureJSON{retracked}, nil, nil, []string{"kept"}},
+		// The same trip at another stop is another departure
+		{"other stop", []streamDepartureJSON{departure("added", 0)}, []streamDepartureJSON{otherStop}, []string{"added"}, []string{"added"}, nil},
+		{
+			"mixed",
+			[]streamDepartureJSON{departure("kept", 0), departure("removed", 0)},
+			[]streamDepartureJSON{departure("kept", 30), departure("added", 0)},
+			[]string{"added"}, []string{"removed"}, []string{"kept"},
+		},
+	}
+	tripIds := func(departures []streamDepartureJSON) []string {
+		ids := []string{}
+		for _, departure := range departures {
+			ids = append(ids, departure.TripId)
+		}
+		return ids
+	}
+	for _, test := range tests {
+		diff := diffDepartures(test.prev, test.next)
+		if got := tripIds(diff.Added); !slices.Equal(got, test.added) {
+			t.Errorf("%s: added %v, want %v", test.name, got, test.added)
+		}
+		if got := tripIds(diff.Removed); !slices.Equal(got, test.removed) {
+			t.Errorf("%s: removed %v, want %v", test.name, got, test.removed)
+		}
+		if got := tripIds(diff.Retimed); !slices.Equal(got, test.retimed) {
+			t.Errorf("%s: retimed %v, want %v", test.name, got, test.retimed)
+		}
+		if empty := test.added == nil && test.removed == nil && test.retimed == nil; diff.empty() != empty {
+			t.Errorf("%s: empty() = %v, want %v", test.name, diff.empty(), empty)
+		}
+	}
+}
+
+func TestRouteDeparturesStream(t *testing.T) {
+	server := httptest.NewServer(newLoadedServer(t).Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/routes/L/departures/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %s with %s, want an event stream", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	// The first event is a snapshot of the route's departures from every station
+	scanner := bufio.NewScanner(resp.Body)
+	lines := []string{}
+	for len(lines) < 2 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 2 || lines[0] != "event: snapshot" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("got %q, want a snapshot event", lines)
+	}
+	var snapshot snapshotEventJSON
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Departures) != 1 || snapshot.Departures[0].TripId != "DAILY_L1" || snapshot.Departures[0].StopId != "L01N" {
+		t.Errorf("snapshot departures = %+v, want DAILY_L1 from L01N", snapshot.Departures)
+	}
+}
+
+func TestRouteDeparturesStreamOfUnknownRoute(t *testing.T) {
+	var errResponse errorJSON
+	if status := get(t, newLoadedServer(t), "/routes/Z/departures/stream", &errResponse); status != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", status)
+	}
+}
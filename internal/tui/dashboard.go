@@ -0,0 +1,79 @@
+package tui
+
+import (
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/tui/departurecard"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// minDashboardCardWidth is the narrowest a dashboard card is laid out, including its border.
+const minDashboardCardWidth = 48
+
+// syncDashboard creates a departure card for every favorite station, or the selected station
+// if there are none, and sizes the cards to fit the terminal in a grid.
+func (m *model) syncDashboard() {
+	if m.scheduleQuery.Data == nil || m.selectedStation == nil {
+		return
+	}
+
+	stations := []gtfs.Station{}
+	if m.options.Config != nil && len(m.options.Config.Favorites) > 0 {
+		stationIdToStation := map[string]gtfs.Station{}
+		for _, station := range m.scheduleQuery.Data.GetStations() {
+			stationIdToStation[station.StopId] = station
+		}
+		for _, stationId := range m.options.Config.Favorites {
+			if station, exists := stationIdToStation[stationId]; exists {
+				stations = append(stations, station)
+			}
+		}
+	}
+	if len(stations) == 0 {
+		stations = append(stations, *m.selectedStation)
+	}
+
+	// Keep existing cards so that they keep their clocks
+	for len(m.dashboardCards) < len(stations) {
+		m.dashboardCards = append(m.dashboardCards, departurecard.NewModel())
+	}
+	m.dashboardCards = m.dashboardCards[:len(stations)]
+	m.dashboardStations = stations
+
+	columns, rows := dashboardGrid(len(stations), m.width)
+	for i := range m.dashboardCards {
+		// Distribute the remainder so that the grid fills the terminal exactly
+		column, row := i%columns, i/columns
+		m.dashboardCards[i].SetWidth(share(m.width, columns, column))
+		m.dashboardCards[i].SetHeight(share(m.height, rows, row))
+	}
+}
+
+func (m *model) dashboardView() string {
+	columns, _ := dashboardGrid(len(m.dashboardCards), m.width)
+	rows := []string{}
+	for start := 0; start < len(m.dashboardCards); start += columns {
+		row := []string{}
+		for i := start; i < min(start+columns, len(m.dashboardCards)); i++ {
+			row = append(row, m.dashboardCards[i].View())
+		}
+		rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Top, row...))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, rows...)
+}
+
+// dashboardGrid returns how many columns and rows n cards are laid out in to fit width.
+func dashboardGrid(n int, width int) (int, int) {
+	columns := max(1, min(n, width/minDashboardCardWidth))
+	rows := (n + columns - 1) / columns
+	return columns, max(1, rows)
+}
+
+// share returns the size of part i when total is split into n parts as evenly as possible.
+func share(total int, n int, i int) int {
+	size := total / n
+	if i < total%n {
+		size++
+	}
+	return size
+}
@@ -6,6 +6,7 @@ import (
 	"nyct-feed/internal/gtfs"
 	"nyct-feed/internal/tui/routebadge"
 	"nyct-feed/internal/tui/theme"
+	"nyct-feed/internal/tui/trackbadge"
 	"slices"
 	"strings"
 	"time"
@@ -14,16 +15,37 @@ import (
 	"github.com/charmbracelet/lipgloss"
 )
 
-var width = 60
+const defaultWidth = 60
+
+// TripSelectedMsg is emitted when a departure is chosen to view its trip.
+type TripSelectedMsg struct {
+	TripId  string
+	RouteId string
+}
 
 type Model struct {
-	height     int
-	station    gtfs.Station
-	departures []gtfs.Departure
+	focused     bool
+	cursor      int // Index of the selected departure row
+	timeIndex   int // Index of the selected time within the row
+	width       int
+	height      int
+	station     gtfs.Station
+	departures  []gtfs.Departure
+	degraded    []gtfs.FeedState // Feeds whose most recent fetch failed
+	realtimeErr error            // Why the most recent realtime fetch failed, nil if it succeeded
+	alerts      []gtfs.Alert
+	routeAges   map[string]time.Duration // How old each route's realtime data is
+	filter      gtfs.DepartureFilter     // Departures to list
+	clock       func() time.Time         // Time that departure countdowns are relative to
+	status      string                   // Label shown next to the station name, e.g. for stale data
 }
 
 func NewModel() Model {
-	return Model{}
+	return Model{width: defaultWidth, clock: time.Now}
+}
+
+func (m *Model) SetWidth(width int) {
+	m.width = width - 2 // Left and right border
 }
 
 func (m *Model) SetHeight(height int) {
@@ -38,22 +60,151 @@ func (m *Model) SetDepartures(departures []gtfs.Departure) {
 	m.departures = departures
 }
 
+// SetDegradedFeeds marks routes served by the given feeds as showing outdated departures.
+func (m *Model) SetDegradedFeeds(degraded []gtfs.FeedState) {
+	m.degraded = degraded
+}
+
+// servedBy reports whether the feed may carry any route of the station.
+// A feed whose routes are unknown is assumed to, unless it only carries alerts.
+func (m *Model) servedBy(feed gtfs.FeedState) bool {
+	if feed.AlertsOnly() {
+		return false
+	}
+	routeIds := feed.RouteIds()
+	if len(routeIds) == 0 {
+		return true
+	}
+	for _, route := range m.station.Routes {
+		if _, exists := routeIds[route.RouteId]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRealtimeError shows why the most recent realtime fetch failed. Nil hides the warning.
+func (m *Model) SetRealtimeError(err error) {
+	m.realtimeErr = err
+}
+
+// SetRouteAges sets how old each route's realtime data is. Routes with stale data are annotated
+// and the countdowns of routes without live data are dimmed.
+func (m *Model) SetRouteAges(routeAges map[string]time.Duration) {
+	m.routeAges = routeAges
+}
+
+// SetFilter limits the listed routes and departures.
+func (m *Model) SetFilter(filter gtfs.DepartureFilter) {
+	m.filter = filter
+}
+
+// SetAlerts sets the service alerts shown above the departures.
+func (m *Model) SetAlerts(alerts []gtfs.Alert) {
+	m.alerts = alerts
+}
+
+// SetClock sets the clock that departure countdowns are computed against,
+// e.g. the time of a recorded snapshot or a simulated replay clock.
+func (m *Model) SetClock(clock func() time.Time) {
+	m.clock = clock
+}
+
+// SetStatus sets a label shown next to the station name. An empty status hides the label.
+func (m *Model) SetStatus(status string) {
+	m.status = status
+}
+
+// Focus lets the departure rows be navigated and selected with the keyboard.
+func (m *Model) Focus() {
+	m.focused = true
+}
+
+func (m *Model) Blur() {
+	m.focused = false
+}
+
+func (m *Model) Focused() bool {
+	return m.focused
+}
+
 func (m *Model) Init() tea.Cmd {
 	return nil
 }
 
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
-	return m, cmd
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !m.focused || !ok {
+		return m, nil
+	}
+
+	rows := m.getRows(m.clock())
+	switch keyMsg.String() {
+	case "up", "k":
+		m.cursor--
+		m.timeIndex = 0
+	case "down", "j":
+		m.cursor++
+		m.timeIndex = 0
+	case "left", "h":
+		m.timeIndex--
+	case "right", "l":
+		m.timeIndex++
+	case "enter":
+		m.clampSelection(rows)
+		if len(rows) == 0 {
+			return m, nil
+		}
+		row := rows[m.cursor]
+		selected := TripSelectedMsg{
+			TripId:  row.upcomingTimes[m.timeIndex].TripId,
+			RouteId: row.departure.RouteId,
+		}
+		return m, func() tea.Msg { return selected }
+	}
+	m.clampSelection(rows)
+
+	return m, nil
+}
+
+// departureRow is a departure with upcoming times, as listed in the card.
+type departureRow struct {
+	departure     gtfs.Departure
+	upcomingTimes []gtfs.DepartureTime
+}
+
+// getRows returns the departures with upcoming times in the order they are listed.
+func (m *Model) getRows(now time.Time) []departureRow {
+	rows := []departureRow{}
+	for _, route := range m.filteredRoutes() {
+		for _, departure := range m.departures {
+			if departure.RouteId != route.RouteId || !m.filter.Matches(departure) {
+				continue
+			}
+			upcomingTimes := getUpcomingDepartureTimes(departure.Times, now)
+			if len(upcomingTimes) > 0 {
+				rows = append(rows, departureRow{departure, upcomingTimes})
+			}
+		}
+	}
+	return rows
+}
+
+// clampSelection keeps the selected row and time within the listed departures.
+func (m *Model) clampSelection(rows []departureRow) {
+	m.cursor = min(max(m.cursor, 0), max(len(rows)-1, 0))
+	if len(rows) == 0 {
+		m.timeIndex = 0
+		return
+	}
+	m.timeIndex = min(max(m.timeIndex, 0), len(rows[m.cursor].upcomingTimes)-1)
 }
 
 var baseStyle = lipgloss.NewStyle().
-	Width(width).
 	Border(lipgloss.RoundedBorder()).
 	BorderForeground(theme.Border)
 
 var titleStyle = lipgloss.NewStyle().
-	Width(width).
 	Padding(0, 1).
 	Foreground(theme.Strong).
 	Border(lipgloss.NormalBorder(), false, false, true, false).
@@ -62,68 +213,153 @@ var titleStyle = lipgloss.NewStyle().
 var mutedTextStyle = lipgloss.NewStyle().
 	Foreground(theme.Subtle)
 
+var statusTextStyle = lipgloss.NewStyle().
+	Foreground(theme.Warning)
+
 var routeHeadingStyle = lipgloss.NewStyle().
-	Width(width).
 	Padding(1, 1, 0, 1).
 	BorderForeground(theme.Border)
 
+var alertStyle = lipgloss.NewStyle().
+	Padding(1, 1, 0, 1).
+	Foreground(theme.Strong)
+
+var degradedRowStyle = lipgloss.NewStyle().
+	Padding(0, 1)
+
 var departureRowStyle = lipgloss.NewStyle().
-	Width(width).
 	Padding(0, 1).
 	Foreground(theme.Strong)
-var departureInnerWidth = departureRowStyle.GetWidth() - departureRowStyle.GetHorizontalFrameSize()
 
 var (
-	directionStyle   = lipgloss.NewStyle().PaddingRight(1).Foreground(theme.Strong)
-	destinationStyle = lipgloss.NewStyle().Foreground(theme.Strong)
-	timesStyle       = lipgloss.NewStyle().PaddingRight(1).Foreground(theme.Strong)
-	realtimeStyle    = lipgloss.NewStyle().Foreground(theme.Realtime)
-	spacingStyle     = lipgloss.NewStyle().Foreground(theme.Border)
+	directionStyle         = lipgloss.NewStyle().PaddingRight(1).Foreground(theme.Strong)
+	destinationStyle       = lipgloss.NewStyle().Foreground(theme.Strong)
+	timesStyle             = lipgloss.NewStyle().PaddingRight(1).Foreground(theme.Strong)
+	deadTimesStyle         = lipgloss.NewStyle().PaddingRight(1).Foreground(theme.Subtle).Faint(true)
+	realtimeStyle          = lipgloss.NewStyle().Foreground(theme.Realtime)
+	selectedDirectionStyle = lipgloss.NewStyle().PaddingRight(1).Foreground(theme.Active)
+	selectedTimeStyle      = lipgloss.NewStyle().Underline(true)
+	scheduledStyle         = lipgloss.NewStyle().Foreground(theme.Subtle)
+	cancelledStyle         = lipgloss.NewStyle().Foreground(theme.Subtle).Strikethrough(true)
+	unassignedStyle        = lipgloss.NewStyle().Foreground(theme.Subtle).Italic(true)
+	delayStyle             = lipgloss.NewStyle().Foreground(theme.Warning)
+	spacingStyle           = lipgloss.NewStyle().Foreground(theme.Border)
 )
 
 var w = lipgloss.Width
 
 func (m *Model) View() string {
-	now := time.Now()
+	now := m.clock()
 	content := []string{}
 
-	title := titleStyle.Render(m.station.StopName)
+	titleText := m.station.StopName
+	if m.status != "" {
+		label := statusTextStyle.Render(m.status)
+		spacing := strings.Repeat(" ", max(1, m.width-titleStyle.GetHorizontalFrameSize()-w(titleText)-w(label)))
+		titleText = titleText + spacing + label
+	}
+	title := titleStyle.Width(m.width).Render(titleText)
 	content = append(content, title)
 
-	for _, route := range m.station.Routes {
+	if !m.filter.IsZero() {
+		content = append(content, degradedRowStyle.Width(m.width).Render(m.renderFilter()))
+	}
+
+	if m.realtimeErr != nil {
+		failed := statusTextStyle.Render("⚠ Realtime update failed: " + m.realtimeErr.Error())
+		content = append(content, degradedRowStyle.Width(m.width).Render(failed))
+	}
+
+	for _, alert := range m.alerts {
+		content = append(content, m.renderAlert(alert))
+	}
+
+	// Routes of degraded feeds are known from their last good message
+	routeIdToUpdatedAt := map[string]time.Time{}
+	for _, feed := range m.degraded {
+		if feed.Msg == nil {
+			if !m.servedBy(feed) {
+				continue
+			}
+			unavailable := statusTextStyle.Render(fmt.Sprintf("⚠ %s feed unavailable", feed.Name))
+			content = append(content, degradedRowStyle.Width(m.width).Render(unavailable))
+			continue
+		}
+		for routeId := range feed.RouteIds() {
+			routeIdToUpdatedAt[routeId] = feed.UpdatedAt
+		}
+	}
+
+	rows := m.getRows(now)
+	m.clampSelection(rows)
+
+	for _, route := range m.filteredRoutes() {
 		badge := routebadge.RenderOne(route)
-		heading := routeHeadingStyle.Render(badge)
+		age, hasAge := m.routeAges[route.RouteId]
+		freshness := gtfs.FreshnessOf(age)
+		if updatedAt, exists := routeIdToUpdatedAt[route.RouteId]; exists {
+			badge += statusTextStyle.Render(" ⚠ Feed degraded · " + getFormattedAge(updatedAt, now))
+		} else if hasAge && freshness == gtfs.Stale {
+			badge += statusTextStyle.Render(" ⚠ Data " + getFormattedDuration(age) + " old")
+		} else if hasAge && freshness == gtfs.Dead {
+			badge += mutedTextStyle.Render(" ✕ No live data for " + getFormattedDuration(age))
+		}
+		heading := routeHeadingStyle.Width(m.width).Render(badge)
 		content = append(content, heading)
 
-		for _, departure := range m.departures {
-			if departure.RouteId == route.RouteId {
-				departureTimes := getFormattedDepartureTimes(departure.Times, now)
-				if departureTimes == "No Departures" {
-					continue
-				}
-
-				timesStr := timesStyle.Render(departureTimes)
-				direction := directionStyle.Render("(" + string(departure.StopId[len(departure.StopId)-1]) + ")")
-				destination := destinationStyle.Render(departure.FinalStopName)
-				realtime := realtimeStyle.Render("•")
-				availableWidth := departureInnerWidth - w(direction) - w(destination) - w(timesStr) - w(realtime)
-				spacing := spacingStyle.Render(strings.Repeat(" ", max(1, availableWidth)))
-
-				departureRow := departureRowStyle.Render(lipgloss.JoinHorizontal(
-					lipgloss.Left,
-					direction,
-					destination,
-					spacing,
-					timesStr,
-					realtime,
-				))
-
-				content = append(content, departureRow)
+		for i, row := range rows {
+			if row.departure.RouteId != route.RouteId {
+				continue
+			}
+			isSelected := m.focused && i == m.cursor
+			selectedTime := -1
+			if isSelected {
+				selectedTime = m.timeIndex
+			}
+
+			departure := row.departure
+			timesStr := timesStyle.Render(getFormattedDepartureTimes(row.upcomingTimes, now, selectedTime))
+			if hasAge && freshness == gtfs.Dead {
+				timesStr = deadTimesStyle.Render(getFormattedDepartureTimes(row.upcomingTimes, now, selectedTime))
+			}
+			direction := directionStyle.Render("(" + string(departure.StopId[len(departure.StopId)-1]) + ")")
+			if isSelected {
+				direction = selectedDirectionStyle.Render("▸" + string(departure.StopId[len(departure.StopId)-1]) + " ")
+			}
+			destination := destinationStyle.Render(departure.FinalStopName)
+			// Show the track of the selected train, or the next one
+			trackTime := row.upcomingTimes[0]
+			if isSelected {
+				trackTime = row.upcomingTimes[m.timeIndex]
 			}
+			track := trackbadge.Render(trackTime.Track)
+			if track != "" {
+				track += " "
+			}
+			realtime := renderSource(row.upcomingTimes[0].Source)
+			availableWidth := m.width - departureRowStyle.GetHorizontalFrameSize() - w(direction) - w(destination) - w(timesStr) - w(track) - w(realtime)
+			spacing := spacingStyle.Render(strings.Repeat(" ", max(1, availableWidth)))
+
+			departureRow := departureRowStyle.Width(m.width).Render(lipgloss.JoinHorizontal(
+				lipgloss.Left,
+				direction,
+				destination,
+				spacing,
+				timesStr,
+				track,
+				realtime,
+			))
+
+			content = append(content, departureRow)
 		}
 	}
 
-	return baseStyle.Height(m.height).Render(
+	style := baseStyle
+	if m.focused {
+		style = style.BorderForeground(theme.Active)
+	}
+	// Clip content that does not fit, e.g. when cards are laid out in a grid
+	return style.Width(m.width).Height(m.height).MaxHeight(m.height + 2).Render(
 		lipgloss.JoinVertical(
 			lipgloss.Top,
 			content...,
@@ -131,34 +367,129 @@ func (m *Model) View() string {
 	)
 }
 
-// getFormattedDepartureTimes returns the soonest upcoming departures as a string.
-// If there are no upcoming departures "No Departures" is returned.
-// Example: "Now, 8 min"
-func getFormattedDepartureTimes(departureTimes []time.Time, now time.Time) string {
-	slices.SortFunc(departureTimes, func(a, b time.Time) int {
-		return a.Compare(b)
-	})
+// filteredRoutes returns the station's routes included by the filter.
+func (m *Model) filteredRoutes() []gtfs.Route {
+	routes := []gtfs.Route{}
+	for _, route := range m.station.Routes {
+		if m.filter.MatchesRoute(route.RouteId) {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
 
-	durations := []string{}
+// renderFilter describes the filter, e.g. "Only 1 (N)".
+func (m *Model) renderFilter() string {
+	label := mutedTextStyle.Render("Only ")
+	if len(m.filter.RouteIds) > 0 {
+		label += routebadge.RenderMany(m.filteredRoutes())
+	}
+	if m.filter.Direction != "" {
+		label += mutedTextStyle.Render("(" + m.filter.Direction + ")")
+	}
+	return label
+}
+
+// renderAlert renders an alert's header preceded by badges of the station's routes it informs.
+func (m *Model) renderAlert(alert gtfs.Alert) string {
+	routes := []gtfs.Route{}
+	for _, route := range m.station.Routes {
+		if slices.Contains(alert.RouteIds, route.RouteId) {
+			routes = append(routes, route)
+		}
+	}
+
+	prefix := statusTextStyle.Render("⚠ ")
+	if len(routes) > 0 {
+		prefix += routebadge.RenderMany(routes)
+	}
+	return alertStyle.Width(m.width).Render(prefix + alert.Header)
+}
+
+// getUpcomingDepartureTimes returns the (at most 3) soonest departure times that have not yet passed.
+func getUpcomingDepartureTimes(departureTimes []gtfs.DepartureTime, now time.Time) []gtfs.DepartureTime {
+	upcoming := []gtfs.DepartureTime{}
 	for _, departureTime := range departureTimes {
-		if len(durations) == 3 {
+		if len(upcoming) == 3 {
 			break
 		}
-		minTilDeparture := math.Round(departureTime.Sub(now).Minutes())
-		if minTilDeparture > 0 {
-			durations = append(durations, fmt.Sprintf("%v", minTilDeparture))
-		} else if minTilDeparture == 0 {
-			durations = append(durations, "Now")
+		if math.Round(departureTime.Time().Sub(now).Minutes()) >= 0 {
+			upcoming = append(upcoming, departureTime)
 		}
 	}
+	return upcoming
+}
 
-	if len(durations) == 0 {
+// getFormattedDepartureTimes returns the given upcoming departures as a string.
+// Lateness is shown after each time, cancelled departures are struck through,
+// trains not yet assigned are dimmed and marked with "?" and the time at index
+// selected (if any) is underlined.
+// If there are no upcoming departures "No Departures" is returned.
+// Example: "Now, 8 (+4), 12 min"
+func getFormattedDepartureTimes(upcomingTimes []gtfs.DepartureTime, now time.Time, selected int) string {
+	if len(upcomingTimes) == 0 {
 		return "No Departures"
-	} 
+	}
+
+	durations := []string{}
+	for i, departureTime := range upcomingTimes {
+		minTilDeparture := math.Round(departureTime.Time().Sub(now).Minutes())
+		duration := "Now"
+		if minTilDeparture > 0 {
+			duration = fmt.Sprintf("%v", minTilDeparture)
+		}
+		if i == selected {
+			duration = selectedTimeStyle.Render(duration)
+		}
+
+		if departureTime.Source == gtfs.SourceCancelled {
+			durations = append(durations, cancelledStyle.Render(duration))
+			continue
+		}
+		if departureTime.Unassigned {
+			duration = unassignedStyle.Render(duration + "?")
+		}
+		if delay := math.Round(departureTime.Delay.Minutes()); delay > 0 {
+			duration += delayStyle.Render(fmt.Sprintf(" (+%v)", delay))
+		}
+		durations = append(durations, duration)
+	}
 
 	suffix := ""
-	if durations[len(durations) -1] != "Now" {
+	if math.Round(upcomingTimes[len(upcomingTimes)-1].Time().Sub(now).Minutes()) > 0 {
 		suffix = " min"
 	}
 	return fmt.Sprintf("%s%s", strings.Join(durations, ", "), suffix)
 }
+
+// renderSource renders an indicator of where a departure time comes from.
+func renderSource(source gtfs.DepartureSource) string {
+	switch source {
+	case gtfs.SourceScheduled:
+		return scheduledStyle.Render("◦")
+	case gtfs.SourceCancelled:
+		return delayStyle.Render("×")
+	default:
+		return realtimeStyle.Render("•")
+	}
+}
+
+// getFormattedDuration returns a duration in whole minutes, or hours and minutes.
+// Example: "12m" or "1h 5m"
+func getFormattedDuration(d time.Duration) string {
+	hours, minutes := int(d.Hours()), int(d.Minutes())%60
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+// getFormattedAge returns how long ago updatedAt was.
+// Example: "updated 3m ago"
+func getFormattedAge(updatedAt time.Time, now time.Time) string {
+	age := now.Sub(updatedAt)
+	if age < time.Minute {
+		return fmt.Sprintf("updated %ds ago", max(0, int(age.Seconds())))
+	}
+	return fmt.Sprintf("updated %dm ago", int(age.Minutes()))
+}
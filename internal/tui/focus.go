@@ -0,0 +1,49 @@
+package tui
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/query"
+)
+
+const (
+	// idleTimeout is how long without input before the user is assumed to have walked away
+	idleTimeout = 10 * time.Minute
+	// backgroundPollInterval is the shortest wait between realtime polls while the terminal is unfocused or idle
+	backgroundPollInterval = time.Minute
+)
+
+// recordInput notes that the user is active, resuming regular polling if they were idle.
+func (m *model) recordInput() {
+	m.lastInputAt = time.Now()
+	if m.idle {
+		m.idle = false
+		m.syncFocus()
+	}
+}
+
+// syncFocus tells the query client whether anyone is watching, which slows realtime polling down when not.
+func (m *model) syncFocus() {
+	m.client.SetFocused(!m.blurred && !m.idle)
+}
+
+// realtimeInterval polls just after the feeds are expected to update, backing off while nobody is watching.
+func (m *model) realtimeInterval(q query.Query[*gtfs.Realtime]) time.Duration {
+	interval := q.Data.PollInterval(q.FetchedAt)
+	if !m.client.Focused() {
+		interval = max(interval, backgroundPollInterval)
+	}
+	return interval
+}
+
+type idleCheckMsg struct{}
+
+// checkIdle checks for inactivity once a minute.
+func checkIdle() tea.Cmd {
+	return tea.Tick(time.Minute, func(time.Time) tea.Msg {
+		return idleCheckMsg{}
+	})
+}
@@ -0,0 +1,28 @@
+package tui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleAfterTimeout(t *testing.T) {
+	m := NewModel(Options{})
+	defer m.cancel()
+
+	// The first check comes a minute after startup, well before idleTimeout
+	m.Update(idleCheckMsg{})
+	if m.idle || !m.client.Focused() {
+		t.Fatal("model is idle before idleTimeout without input")
+	}
+
+	m.lastInputAt = time.Now().Add(-idleTimeout)
+	m.Update(idleCheckMsg{})
+	if !m.idle || m.client.Focused() {
+		t.Fatal("model is not idle after idleTimeout without input")
+	}
+
+	m.recordInput()
+	if m.idle || !m.client.Focused() {
+		t.Error("model is still idle after input")
+	}
+}
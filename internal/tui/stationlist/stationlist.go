@@ -1,7 +1,12 @@
 package stationlist
 
 import (
+	"cmp"
+	"fmt"
+	"math"
 	"nyct-feed/internal/gtfs"
+	"slices"
+
 	"nyct-feed/internal/tui/routebadge"
 	"nyct-feed/internal/tui/theme"
 
@@ -14,17 +19,37 @@ const width = 40
 
 type StationSelectedMsg *gtfs.Station
 
+// FavoriteToggledMsg is emitted with a station's ID when it is starred or unstarred.
+type FavoriteToggledMsg string
+
 type stationItem struct {
 	gtfs.Station
+	distance float64 // Meters from the user's location, negative if unknown
+	favorite bool
 }
 
-func (i stationItem) Title() string       { return i.StopName }
-func (i stationItem) Description() string { return routebadge.RenderMany(i.Routes) }
+func (i stationItem) Title() string { return i.StopName }
+
+// Description holds the favorite star since, unlike Title, it is not highlighted when filtering.
+func (i stationItem) Description() string {
+	description := routebadge.RenderMany(i.Routes)
+	if i.favorite {
+		description = favoriteStyle.Render("★ ") + description
+	}
+	if i.distance >= 0 {
+		description += distanceStyle.Render(formatWalk(i.distance))
+	}
+	return description
+}
 func (i stationItem) FilterValue() string { return i.StopName }
 
 type Model struct {
 	selectedStationId string
 	list              list.Model
+	stations          []gtfs.Station
+	location          *gtfs.Coordinates // User's location, nil if unknown
+	sortNearby        bool              // Whether stations are sorted by distance from location
+	favorites         []string          // IDs of favorite stations, pinned to the top of the list in order
 }
 
 func NewModel() Model {
@@ -35,6 +60,8 @@ func NewModel() Model {
 	list.SetShowHelp(false)
 	list.SetShowStatusBar(false)
 	list.DisableQuitKeybindings()
+	// "f" toggles the selected station as a favorite and "d" opens the dashboard instead of paging
+	list.KeyMap.NextPage.SetKeys("right", "l", "pgdown")
 
 	list.Styles.TitleBar = lipgloss.NewStyle().
 		Width(width).
@@ -62,16 +89,67 @@ func (m *Model) SetHeight(height int) {
 }
 
 func (m *Model) SetStations(stations []gtfs.Station) {
-	stationItems := make([]list.Item, len(stations))
-	for i, station := range stations {
-		stationItems[i] = stationItem{station}
+	m.stations = stations
+	m.syncItems()
+}
+
+// SetLocation sets the user's location, showing the walking distance to each station and sorting
+// the nearest stations first. The sort can be toggled back to alphabetical with "s".
+func (m *Model) SetLocation(location gtfs.Coordinates) {
+	m.location = &location
+	m.sortNearby = true
+	m.syncItems()
+}
+
+// SetFavorites pins the stations with the given IDs to the top of the list in the given order.
+func (m *Model) SetFavorites(favorites []string) {
+	m.favorites = slices.Clone(favorites)
+	m.syncItems()
+}
+
+// Select selects the station with the given ID without emitting a [StationSelectedMsg].
+func (m *Model) Select(stationId string) {
+	m.selectedStationId = stationId
+	m.syncItems()
+}
+
+// syncItems rebuilds the list items from the stations, keeping the selected station selected.
+func (m *Model) syncItems() {
+	items := make([]stationItem, len(m.stations))
+	for i, station := range m.stations {
+		items[i] = stationItem{
+			Station:  station,
+			distance: -1,
+			favorite: slices.Contains(m.favorites, station.StopId),
+		}
+		if m.location != nil {
+			items[i].distance = gtfs.Distance(*m.location, station.Coordinates())
+		}
+	}
+	slices.SortStableFunc(items, func(a, b stationItem) int {
+		// Favorites come first in the order they were added
+		if a.favorite || b.favorite {
+			return cmp.Compare(m.favoriteRank(a.StopId), m.favoriteRank(b.StopId))
+		}
+		if m.sortNearby {
+			return cmp.Compare(a.distance, b.distance)
+		}
+		return 0
+	})
+
+	stationItems := make([]list.Item, len(items))
+	index := m.list.Index()
+	for i, item := range items {
+		stationItems[i] = item
+		if item.StopId == m.selectedStationId {
+			index = i
+		}
 	}
 
 	// Manually set list state to how it was before updating items
 	// TODO: Causes filter cursor to stop blinking
 	filterText := m.list.FilterValue()
 	filterState := m.list.FilterState()
-	index := m.list.Index()
 
 	m.list.SetItems(stationItems)
 	m.list.SetFilterText(filterText)
@@ -79,6 +157,11 @@ func (m *Model) SetStations(stations []gtfs.Station) {
 	m.list.Select(index)
 }
 
+// SettingFilter reports whether the search input currently has focus.
+func (m *Model) SettingFilter() bool {
+	return m.list.SettingFilter()
+}
+
 func (m *Model) Init() tea.Cmd {
 	return nil
 }
@@ -86,6 +169,23 @@ func (m *Model) Init() tea.Cmd {
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && !m.list.SettingFilter() {
+		switch keyMsg.String() {
+		case "s":
+			if m.location != nil {
+				m.sortNearby = !m.sortNearby
+				m.syncItems()
+				return m, nil
+			}
+		case "f":
+			if item, ok := m.list.SelectedItem().(stationItem); ok {
+				return m, func() tea.Msg {
+					return FavoriteToggledMsg(item.StopId)
+				}
+			}
+		}
+	}
+
 	updatedList, listCmd := m.list.Update(msg)
 	m.list = updatedList
 	if listCmd != nil {
@@ -134,6 +234,28 @@ func renderKbd(key string) string {
 	return style.Render(key)
 }
 
+var distanceStyle = lipgloss.NewStyle().
+	MarginLeft(1).
+	Foreground(theme.Subtle)
+
+var favoriteStyle = lipgloss.NewStyle().
+	Foreground(theme.Warning)
+
+// favoriteRank returns the position of a station among the favorites, or the number of
+// favorites if the station is not one.
+func (m *Model) favoriteRank(stationId string) int {
+	if i := slices.Index(m.favorites, stationId); i != -1 {
+		return i
+	}
+	return len(m.favorites)
+}
+
+// formatWalk returns the walking time to a station distance meters away.
+// Example: "6 min walk"
+func formatWalk(distance float64) string {
+	return fmt.Sprintf("%v min walk", math.Ceil(gtfs.WalkingTime(distance).Minutes()))
+}
+
 var titleStyle = lipgloss.NewStyle().
 	UnsetBackground().
 	Foreground(theme.Subtle)
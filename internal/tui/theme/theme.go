@@ -8,4 +8,5 @@ var (
 	Border   = lipgloss.AdaptiveColor{Light: "#C2B8C2", Dark: "#4D4D4D"}
 	Realtime = lipgloss.AdaptiveColor{Light: "#21ad5b", Dark: "#00dd8c"}
 	Active   = lipgloss.AdaptiveColor{Light: "#F793FF", Dark: "#AD58B4"}
+	Warning  = lipgloss.AdaptiveColor{Light: "#D9822B", Dark: "#F2A541"}
 )
@@ -0,0 +1,27 @@
+package trackbadge
+
+import (
+	"fmt"
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/tui/theme"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	trackStyle        = lipgloss.NewStyle().Foreground(theme.Subtle)
+	trackChangedStyle = lipgloss.NewStyle().Foreground(theme.Warning)
+)
+
+// Render renders the track a train uses, highlighting a change from the scheduled track.
+// Empty if the track is unknown.
+// Example: "Trk 2" or "Trk M→4"
+func Render(track gtfs.Track) string {
+	if track.String() == "" {
+		return ""
+	}
+	if track.Changed() {
+		return trackChangedStyle.Render(fmt.Sprintf("Trk %s→%s", track.Scheduled, track.Actual))
+	}
+	return trackStyle.Render("Trk " + track.String())
+}
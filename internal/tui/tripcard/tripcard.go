@@ -0,0 +1,159 @@
+package tripcard
+
+import (
+	"fmt"
+	"math"
+	"nyct-feed/internal/gtfs"
+	"nyct-feed/internal/tui/routebadge"
+	"nyct-feed/internal/tui/theme"
+	"nyct-feed/internal/tui/trackbadge"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var width = 60
+
+type Model struct {
+	height int
+	route  gtfs.Route
+	trip   gtfs.TripDetail
+	found  bool             // Whether the trip is still running
+	clock  func() time.Time // Time that arrival countdowns are relative to
+}
+
+func NewModel() Model {
+	return Model{clock: time.Now}
+}
+
+func (m *Model) SetHeight(height int) {
+	m.height = height - 2 // Top and bottom border
+}
+
+func (m *Model) SetRoute(route gtfs.Route) {
+	m.route = route
+}
+
+// SetTrip sets the trip's remaining stops. If found is false, the trip is no longer
+// running and the last known stops are kept.
+func (m *Model) SetTrip(trip gtfs.TripDetail, found bool) {
+	if found {
+		m.trip = trip
+	}
+	m.found = found
+}
+
+func (m *Model) SetClock(clock func() time.Time) {
+	m.clock = clock
+}
+
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	return m, cmd
+}
+
+var baseStyle = lipgloss.NewStyle().
+	Width(width).
+	Border(lipgloss.RoundedBorder()).
+	BorderForeground(theme.Active)
+
+var titleStyle = lipgloss.NewStyle().
+	Width(width).
+	Padding(0, 1).
+	Foreground(theme.Strong).
+	Border(lipgloss.NormalBorder(), false, false, true, false).
+	BorderForeground(theme.Border)
+var titleInnerWidth = titleStyle.GetWidth() - titleStyle.GetHorizontalFrameSize()
+
+var infoRowStyle = lipgloss.NewStyle().
+	Width(width).
+	Padding(0, 1)
+
+var stopRowStyle = lipgloss.NewStyle().
+	Width(width).
+	Padding(0, 1).
+	Foreground(theme.Strong)
+var stopInnerWidth = stopRowStyle.GetWidth() - stopRowStyle.GetHorizontalFrameSize()
+
+var (
+	mutedTextStyle  = lipgloss.NewStyle().Foreground(theme.Subtle)
+	statusTextStyle = lipgloss.NewStyle().Foreground(theme.Warning)
+	stopNameStyle   = lipgloss.NewStyle().Foreground(theme.Strong)
+	skippedStyle    = lipgloss.NewStyle().Foreground(theme.Subtle).Strikethrough(true)
+	arrivalStyle    = lipgloss.NewStyle().PaddingLeft(1).Foreground(theme.Strong)
+	delayStyle      = lipgloss.NewStyle().PaddingLeft(1).Foreground(theme.Warning)
+	emptyStyle      = lipgloss.NewStyle().Padding(1).Foreground(theme.Subtle)
+)
+
+var w = lipgloss.Width
+
+func (m *Model) View() string {
+	now := m.clock()
+	content := []string{}
+
+	heading := routebadge.RenderOne(m.route) + " " + m.trip.FinalStopName
+	hint := mutedTextStyle.Render("esc Back")
+	spacing := strings.Repeat(" ", max(1, titleInnerWidth-w(heading)-w(hint)))
+	content = append(content, titleStyle.Render(heading+spacing+hint))
+
+	if m.trip.TrainId != "" {
+		content = append(content, infoRowStyle.Render(mutedTextStyle.Render("Train "+m.trip.TrainId)))
+	}
+	if m.trip.Unassigned {
+		content = append(content, infoRowStyle.Render(statusTextStyle.Render("⚠ Not yet assigned a train, may not run")))
+	}
+	if !m.found {
+		content = append(content, infoRowStyle.Render(statusTextStyle.Render("⚠ Trip is no longer running")))
+	}
+	if len(m.trip.Stops) == 0 {
+		content = append(content, emptyStyle.Render("No remaining stops for this trip"))
+	}
+
+	for _, stop := range m.trip.Stops {
+		name := stopNameStyle.Render(stop.StopName)
+		if stop.Skipped {
+			name = skippedStyle.Render(stop.StopName)
+		}
+		track := trackbadge.Render(stop.Track)
+		arrival := arrivalStyle.Render(getFormattedArrival(stop, now))
+		delay := ""
+		if minutes := math.Round(stop.Delay.Minutes()); minutes != 0 {
+			delay = delayStyle.Render(fmt.Sprintf("%+v", minutes))
+		}
+		spacing := strings.Repeat(" ", max(1, stopInnerWidth-w(name)-w(track)-w(arrival)-w(delay)))
+
+		content = append(content, stopRowStyle.Render(name+spacing+track+arrival+delay))
+	}
+
+	return baseStyle.Height(m.height).Render(
+		lipgloss.JoinVertical(
+			lipgloss.Top,
+			content...,
+		),
+	)
+}
+
+// getFormattedArrival returns the arrival time at a stop and the minutes until it.
+// Example: "8:14 PM · 3 min"
+func getFormattedArrival(stop gtfs.TripStop, now time.Time) string {
+	arrival := stop.Predicted
+	if arrival.IsZero() {
+		arrival = stop.Scheduled
+	}
+	if arrival.IsZero() {
+		return "--"
+	}
+
+	clockTime := arrival.In(gtfs.Location).Format("3:04 PM")
+	minTilArrival := math.Round(arrival.Sub(now).Minutes())
+	if minTilArrival <= 0 {
+		return clockTime + " · Now"
+	}
+	return fmt.Sprintf("%s · %v min", clockTime, minTilArrival)
+}
@@ -1,79 +1,274 @@
 package tui
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
+	"nyct-feed/internal/config"
 	"nyct-feed/internal/gtfs"
-	"nyct-feed/internal/pb"
 	"nyct-feed/internal/query"
+	"nyct-feed/internal/replay"
 	"nyct-feed/internal/tui/departurecard"
 	"nyct-feed/internal/tui/splash"
 	"nyct-feed/internal/tui/stationlist"
+	"nyct-feed/internal/tui/theme"
+	"nyct-feed/internal/tui/tripcard"
 )
 
+// maxErrorWidth wraps error messages that are shown in place of the data.
+const maxErrorWidth = 60
+
+var (
+	errorStyle = lipgloss.NewStyle().Foreground(theme.Warning).Align(lipgloss.Center)
+	hintStyle  = lipgloss.NewStyle().Foreground(theme.Subtle)
+)
+
+// Query keys of the schedule and realtime data.
+const (
+	scheduleKey = "schedule"
+	realtimeKey = "realtime"
+)
+
+// Options configures where the model gets its schedule and realtime data.
+type Options struct {
+	// FeedSources are polled for realtime data.
+	FeedSources []gtfs.FeedSource
+	// Offline reads the cached schedule instead of fetching it and labels realtime data with its age.
+	Offline bool
+	// SchedulePath is a local GTFS schedule ZIP folder or directory read instead of fetching the schedule.
+	// An empty path reads the cached schedule when Offline is set.
+	SchedulePath string
+	// Replay plays recorded realtime feeds against a simulated clock instead of fetching them.
+	Replay *replay.Replay
+	// Near is the user's location. Stations are sorted nearest first and the nearest is selected on open.
+	Near *gtfs.Coordinates
+	// Config persists favorite stations, departure filters and the last viewed station. Nothing is persisted if nil.
+	Config *config.Config
+	// Filter replaces the departure filter of the station opened first, if set.
+	Filter *gtfs.DepartureFilter
+	// Dashboard opens on the departures of every favorite station instead of a single station.
+	Dashboard bool
+}
+
 type model struct {
-	scheduleChannel chan query.Query[*gtfs.Schedule]
-	realtimeChannel chan query.Query[[]*pb.FeedMessage]
+	options         Options
+	client          *query.Client
+	ctx             context.Context    // Done on quit
+	cancel          context.CancelFunc // Stops the client's queries and their fetches in flight on quit
+	scheduleChannel <-chan query.Query[*gtfs.Schedule]
+	realtimeChannel <-chan query.Query[*gtfs.Realtime]
 	scheduleQuery   query.Query[*gtfs.Schedule]
-	realtimeQuery   query.Query[[]*pb.FeedMessage]
+	realtimeQuery   query.Query[*gtfs.Realtime]
 	stationList     stationlist.Model
 	departureCard   departurecard.Model
+	tripCard        tripcard.Model
 	selectedStation *gtfs.Station
-	width           int
-	height          int
+	selectedTripId  string // Trip shown in place of the departures, empty if none
+	dashboard       bool   // Whether the departures of every favorite station are shown at once
+	// Departure cards of the dashboard and the stations they show, in the same order
+	dashboardCards    []departurecard.Model
+	dashboardStations []gtfs.Station
+	blurred           bool      // Whether the terminal reported losing focus
+	idle              bool      // Whether there has been no input for idleTimeout
+	lastInputAt       time.Time // When the user last pressed a key, or the model was created if they have not
+	width             int
+	height            int
 }
 
-func NewModel() model {
-	return model{
-		scheduleChannel: make(chan query.Query[*gtfs.Schedule]),
-		realtimeChannel: make(chan query.Query[[]*pb.FeedMessage]),
-		stationList:     stationlist.NewModel(),
-		departureCard:   departurecard.NewModel(),
+func NewModel(options Options) model {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := model{
+		options:       options,
+		client:        query.NewClient(ctx),
+		ctx:           ctx,
+		cancel:        cancel,
+		dashboard:     options.Dashboard,
+		stationList:   stationlist.NewModel(),
+		departureCard: departurecard.NewModel(),
+		tripCard:      tripcard.NewModel(),
+		lastInputAt:   time.Now(),
 	}
+	if options.Near != nil {
+		m.stationList.SetLocation(*options.Near)
+	}
+	if options.Config != nil {
+		m.stationList.SetFavorites(options.Config.Favorites)
+	}
+	return m
 }
 
 func (m *model) Init() tea.Cmd {
+	m.scheduleChannel, _ = query.Subscribe(m.client, scheduleKey, m.scheduleOptions())
+	if m.options.Replay != nil {
+		realtimeChannel := make(chan query.Query[*gtfs.Realtime])
+		m.realtimeChannel = realtimeChannel
+		m.options.Replay.Start(m.ctx, realtimeChannel)
+		return tea.Batch(
+			getScheduleQuery(m.scheduleChannel),
+			getRealtimeQuery(m.realtimeChannel),
+			tickReplay(),
+			checkIdle(),
+		)
+	}
+	m.realtimeChannel, _ = query.Subscribe(m.client, realtimeKey, m.realtimeOptions())
 	return tea.Batch(
-		createScheduleQuery(m.scheduleChannel),
-		createRealtimeQuery(m.realtimeChannel),
 		getScheduleQuery(m.scheduleChannel),
 		getRealtimeQuery(m.realtimeChannel),
+		checkIdle(),
 	)
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		m.recordInput()
 		if msg.String() == "ctrl+c" {
+			m.cancel()
+			m.saveConfig()
 			return m, tea.Quit
 		}
+		if msg.String() == "r" && m.options.Replay == nil && !m.stationList.SettingFilter() {
+			if m.scheduleQuery.Data == nil {
+				m.client.Refetch(scheduleKey)
+			}
+			m.client.Refetch(realtimeKey)
+			return m, nil
+		}
+		if m.options.Replay != nil && !m.stationList.SettingFilter() {
+			switch msg.String() {
+			case "+":
+				m.options.Replay.CycleSpeed(1)
+				m.syncDepartureCards()
+				return m, nil
+			case "-":
+				m.options.Replay.CycleSpeed(-1)
+				m.syncDepartureCards()
+				return m, nil
+			}
+		}
+		if m.dashboard {
+			// The station list and departure selection are hidden in the dashboard
+			switch msg.String() {
+			case "d", "esc":
+				m.dashboard = false
+			}
+			return m, nil
+		}
+		if !m.stationList.SettingFilter() {
+			switch msg.String() {
+			case "d":
+				m.dashboard = true
+				m.syncDashboard()
+				m.syncDepartureCards()
+				return m, nil
+			case "o":
+				m.cycleRouteFilter()
+				return m, nil
+			case "n":
+				m.cycleDirectionFilter()
+				return m, nil
+			case "tab":
+				if m.departureCard.Focused() {
+					m.departureCard.Blur()
+				} else {
+					m.departureCard.Focus()
+				}
+				return m, nil
+			case "esc":
+				if m.selectedTripId != "" {
+					m.selectedTripId = ""
+					return m, nil
+				}
+			}
+		}
+		if m.departureCard.Focused() {
+			updatedModel, cmd := m.departureCard.Update(msg)
+			m.departureCard = *updatedModel.(*departurecard.Model)
+			return m, cmd
+		}
+
+	case departurecard.TripSelectedMsg:
+		m.selectedTripId = msg.TripId
+		for _, route := range m.selectedStation.Routes {
+			if route.RouteId == msg.RouteId {
+				m.tripCard.SetRoute(route)
+			}
+		}
+		m.syncDepartureCards()
+		return m, nil
+
+	case replayTickMsg:
+		m.syncDepartureCards()
+		return m, tickReplay()
 
 	case tea.WindowSizeMsg:
 		m.width, m.height = msg.Width, msg.Height
 		m.stationList.SetHeight(m.height)
 		m.departureCard.SetHeight(m.height)
+		m.tripCard.SetHeight(m.height)
+		m.syncDashboard()
+		return m, nil
+
+	case tea.FocusMsg:
+		m.blurred = false
+		m.syncFocus()
 		return m, nil
 
+	case tea.BlurMsg:
+		m.blurred = true
+		m.syncFocus()
+		return m, nil
+
+	case idleCheckMsg:
+		if !m.idle && time.Since(m.lastInputAt) >= idleTimeout {
+			m.idle = true
+			m.syncFocus()
+		}
+		return m, checkIdle()
+
 	case gotScheduleQueryMsg:
 		m.scheduleQuery = query.Query[*gtfs.Schedule](msg)
 		if m.scheduleQuery.Data != nil && m.selectedStation == nil {
-			m.selectedStation = &m.scheduleQuery.Data.GetStations()[0]
+			m.selectedStation = m.initialStation(m.scheduleQuery.Data)
+			m.stationList.Select(m.selectedStation.StopId)
+			if m.options.Filter != nil && m.options.Config != nil {
+				m.options.Config.SetFilter(m.selectedStation.StopId, *m.options.Filter)
+				m.saveConfig()
+			}
 		}
 		m.syncStationList()
+		m.syncDashboard()
 		m.syncDepartureCards()
 		return m, getScheduleQuery(m.scheduleChannel)
 
 	case gotRealtimeQueryMsg:
-		m.realtimeQuery = query.Query[[]*pb.FeedMessage](msg)
+		m.realtimeQuery = query.Query[*gtfs.Realtime](msg)
 		m.syncDepartureCards()
 		return m, getRealtimeQuery(m.realtimeChannel)
 
 	case stationlist.StationSelectedMsg:
 		m.selectedStation = msg
+		m.selectedTripId = ""
 		m.syncDepartureCards()
+		if m.options.Config != nil {
+			m.options.Config.LastStationId = msg.StopId // Saved on quit rather than on every cursor move
+		}
+		return m, nil
+
+	case stationlist.FavoriteToggledMsg:
+		if m.options.Config != nil {
+			m.options.Config.ToggleFavorite(string(msg))
+			m.stationList.SetFavorites(m.options.Config.Favorites)
+			m.saveConfig()
+			m.syncDashboard()
+			m.syncDepartureCards()
+		}
 		return m, nil
 	}
 
@@ -89,6 +284,19 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m *model) View() string {
+	if m.scheduleQuery.Data == nil && m.scheduleQuery.Error != nil {
+		// Nothing can be shown without the schedule
+		return lipgloss.NewStyle().
+			Width(m.width).
+			Height(m.height).
+			Align(lipgloss.Center, lipgloss.Center).
+			Render(lipgloss.JoinVertical(
+				lipgloss.Center,
+				splash.Model{}.View(),
+				errorStyle.Width(min(m.width, maxErrorWidth)).Render("Failed to load schedule: "+m.scheduleQuery.Error.Error()),
+				hintStyle.Render("Press r to retry"),
+			))
+	}
 	if m.scheduleQuery.Status == query.Pending || m.realtimeQuery.Status == query.Pending {
 		return lipgloss.NewStyle().
 			Width(m.width).
@@ -96,16 +304,182 @@ func (m *model) View() string {
 			Align(lipgloss.Center, lipgloss.Center).
 			Render(splash.Model{}.View())
 	}
+	if m.dashboard {
+		return m.dashboardView()
+	}
+	if m.selectedTripId != "" {
+		return lipgloss.JoinHorizontal(lipgloss.Left, m.stationList.View(), m.tripCard.View())
+	}
 	return lipgloss.JoinHorizontal(lipgloss.Left, m.stationList.View(), m.departureCard.View())
 }
 
 func (m *model) syncDepartureCards() {
-	if m.scheduleQuery.Data != nil && m.realtimeQuery.Data != nil {
-		stationId := m.selectedStation.StopId
-		stopIds := []string{stationId + "N", stationId + "S"}
-		departures := gtfs.FindDepartures(stopIds, m.realtimeQuery.Data, m.scheduleQuery.Data)
-		m.departureCard.SetDepartures(departures)
-		m.departureCard.SetStation(*m.selectedStation)
+	if m.scheduleQuery.Data != nil && m.selectedStation != nil {
+		m.syncClock()
+		m.syncDepartureCard(&m.departureCard, *m.selectedStation)
+		for i := range m.dashboardCards {
+			m.syncDepartureCard(&m.dashboardCards[i], m.dashboardStations[i])
+		}
+
+		if m.selectedTripId != "" {
+			realtime := m.realtimeData().Messages()
+			m.tripCard.SetTrip(gtfs.FindTripDetail(m.selectedTripId, realtime, m.scheduleQuery.Data, m.now()))
+		}
+	}
+}
+
+// realtimeData returns the latest realtime data, which is empty until it has been fetched successfully
+// so that scheduled departures are shown in the meantime.
+func (m *model) realtimeData() *gtfs.Realtime {
+	if m.realtimeQuery.Data == nil {
+		return &gtfs.Realtime{}
+	}
+	return m.realtimeQuery.Data
+}
+
+// syncDepartureCard shows the departures and alerts of the station on card.
+func (m *model) syncDepartureCard(card *departurecard.Model, station gtfs.Station) {
+	stopIds := []string{station.StopId + "N", station.StopId + "S"}
+	realtime := m.realtimeData().Messages()
+	departures := gtfs.FindDepartures(stopIds, realtime, m.scheduleQuery.Data, m.now())
+	card.SetDepartures(departures)
+	card.SetStation(station)
+	filter := gtfs.DepartureFilter{}
+	if m.options.Config != nil {
+		filter = m.options.Config.Filter(station.StopId)
+	}
+	card.SetFilter(filter)
+	card.SetDegradedFeeds(m.realtimeData().DegradedFeeds())
+	card.SetRouteAges(m.realtimeData().RouteAges(m.now()))
+	card.SetRealtimeError(m.realtimeQuery.Error)
+
+	routeIds := []string{}
+	for _, route := range station.Routes {
+		if filter.MatchesRoute(route.RouteId) {
+			routeIds = append(routeIds, route.RouteId)
+		}
+	}
+	alerts := gtfs.FindAlerts([]string{station.StopId}, routeIds, realtime, m.now())
+	card.SetAlerts(alerts)
+}
+
+// initialStation returns the station shown on open: the nearest station to the user's location if
+// given, otherwise the last viewed station, the first favorite or the first station.
+func (m *model) initialStation(schedule *gtfs.Schedule) *gtfs.Station {
+	if m.options.Near != nil {
+		return &schedule.FindNearbyStations(*m.options.Near, 1)[0].Station
+	}
+
+	stations := schedule.GetStations()
+	if cfg := m.options.Config; cfg != nil {
+		for _, stationId := range append([]string{cfg.LastStationId}, cfg.Favorites...) {
+			for i := range stations {
+				if stations[i].StopId == stationId {
+					return &stations[i]
+				}
+			}
+		}
+	}
+	return &stations[0]
+}
+
+// cycleRouteFilter limits the selected station's departures to each of its routes in turn,
+// then shows every route again.
+func (m *model) cycleRouteFilter() {
+	if m.options.Config == nil || m.selectedStation == nil {
+		return
+	}
+	stationId := m.selectedStation.StopId
+	filter := m.options.Config.Filter(stationId)
+
+	next := 0
+	if len(filter.RouteIds) > 0 {
+		next = 1 + slices.IndexFunc(m.selectedStation.Routes, func(route gtfs.Route) bool {
+			return route.RouteId == filter.RouteIds[0]
+		})
+	}
+	filter.RouteIds = nil
+	if next < len(m.selectedStation.Routes) {
+		filter.RouteIds = []string{m.selectedStation.Routes[next].RouteId}
+	}
+
+	m.options.Config.SetFilter(stationId, filter)
+	m.saveConfig()
+	m.syncDepartureCards()
+}
+
+// cycleDirectionFilter limits the selected station's departures to northbound, then southbound,
+// then shows both directions again.
+func (m *model) cycleDirectionFilter() {
+	if m.options.Config == nil || m.selectedStation == nil {
+		return
+	}
+	stationId := m.selectedStation.StopId
+	filter := m.options.Config.Filter(stationId)
+	switch filter.Direction {
+	case "":
+		filter.Direction = "N"
+	case "N":
+		filter.Direction = "S"
+	default:
+		filter.Direction = ""
+	}
+
+	m.options.Config.SetFilter(stationId, filter)
+	m.saveConfig()
+	m.syncDepartureCards()
+}
+
+func (m *model) saveConfig() {
+	if m.options.Config == nil {
+		return
+	}
+	if err := m.options.Config.Save(); err != nil {
+		log.Printf("Error saving config: %v", err)
+	}
+}
+
+// now returns the time that the realtime data is displayed at.
+func (m *model) now() time.Time {
+	switch {
+	case m.options.Replay != nil:
+		return m.options.Replay.Clock().Now()
+	case m.options.Offline && m.realtimeQuery.Data != nil:
+		return gtfs.FeedTimestamp(m.realtimeQuery.Data.Messages())
+	default:
+		return time.Now()
+	}
+}
+
+// syncClock points departure countdowns at the time the realtime data represents
+// and labels data that is not live.
+func (m *model) syncClock() {
+	var clock func() time.Time
+	var status string
+	switch {
+	case m.options.Replay != nil:
+		r := m.options.Replay
+		clock = r.Clock().Now
+		select {
+		case <-r.Done():
+			status = "Replay ended"
+		default:
+			status = fmt.Sprintf("Replay %gx · %s", r.Clock().Speed(), r.Clock().Now().Format("3:04:05 PM"))
+		}
+	case m.options.Offline:
+		snapshotTime := m.now()
+		clock = func() time.Time { return snapshotTime }
+		status = formatSnapshotAge(snapshotTime, time.Now())
+	default:
+		return
+	}
+
+	m.tripCard.SetClock(clock)
+	m.departureCard.SetClock(clock)
+	m.departureCard.SetStatus(status)
+	for i := range m.dashboardCards {
+		m.dashboardCards[i].SetClock(clock)
+		m.dashboardCards[i].SetStatus(status)
 	}
 }
 
@@ -116,40 +490,73 @@ func (m *model) syncStationList() {
 	}
 }
 
+func (m *model) scheduleOptions() query.QueryOptions[*gtfs.Schedule] {
+	return query.QueryOptions[*gtfs.Schedule]{
+		QueryFn: m.scheduleFn(),
+		RefetchIntervalFn: func(q query.Query[*gtfs.Schedule]) time.Duration {
+			return q.Data.RefetchInterval(q.FetchedAt)
+		},
+		Retry: 3,
+	}
+}
+
+func (m *model) realtimeOptions() query.QueryOptions[*gtfs.Realtime] {
+	return query.QueryOptions[*gtfs.Realtime]{
+		QueryFn:           m.realtimeFn(),
+		RefetchIntervalFn: m.realtimeInterval,
+		Timeout:           gtfs.PollTimeout,
+		Retry:             3,
+	}
+}
+
+func (m *model) scheduleFn() func(context.Context) (*gtfs.Schedule, error) {
+	if m.options.Offline || m.options.SchedulePath != "" {
+		return func(context.Context) (*gtfs.Schedule, error) {
+			return gtfs.GetLocalSchedule(m.options.SchedulePath)
+		}
+	}
+	return gtfs.NewScheduleFetcher()
+}
+
+func (m *model) realtimeFn() func(context.Context) (*gtfs.Realtime, error) {
+	return gtfs.NewRealtimePoller(m.options.FeedSources).Poll
+}
+
 type gotScheduleQueryMsg query.Query[*gtfs.Schedule]
 
-func getScheduleQuery(scheduleChannel chan query.Query[*gtfs.Schedule]) tea.Cmd {
+func getScheduleQuery(scheduleChannel <-chan query.Query[*gtfs.Schedule]) tea.Cmd {
 	return func() tea.Msg {
 		return gotScheduleQueryMsg(<-scheduleChannel)
 	}
 }
 
-type gotRealtimeQueryMsg query.Query[[]*pb.FeedMessage]
+type gotRealtimeQueryMsg query.Query[*gtfs.Realtime]
 
-func getRealtimeQuery(realtimeChannel chan query.Query[[]*pb.FeedMessage]) tea.Cmd {
+func getRealtimeQuery(realtimeChannel <-chan query.Query[*gtfs.Realtime]) tea.Cmd {
 	return func() tea.Msg {
 		return gotRealtimeQueryMsg(<-realtimeChannel)
 	}
 }
 
-func createScheduleQuery(scheduleChannel chan query.Query[*gtfs.Schedule]) tea.Cmd {
-	return func() tea.Msg {
-		query.CreateQuery[*gtfs.Schedule](query.QueryOptions[*gtfs.Schedule]{
-			QueryChannel:    scheduleChannel,
-			QueryFn:         gtfs.GetSchedule,
-			RefetchInterval: time.Hour,
-		})
-		return nil
-	}
+type replayTickMsg struct{}
+
+// tickReplay re-renders every second so countdowns follow the simulated clock between frames.
+func tickReplay() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return replayTickMsg{}
+	})
 }
 
-func createRealtimeQuery(realtimeChannel chan query.Query[[]*pb.FeedMessage]) tea.Cmd {
-	return func() tea.Msg {
-		query.CreateQuery[[]*pb.FeedMessage](query.QueryOptions[[]*pb.FeedMessage]{
-			QueryChannel:    realtimeChannel,
-			QueryFn:         gtfs.GetRealtime,
-			RefetchInterval: time.Second * 5,
-		})
-		return nil
+// formatSnapshotAge labels recorded data with its age.
+// Example: "Offline · 2h 5m old"
+func formatSnapshotAge(snapshotTime time.Time, now time.Time) string {
+	age := now.Sub(snapshotTime).Truncate(time.Minute)
+	if age < time.Minute {
+		return "Offline · <1m old"
+	}
+	hours, minutes := int(age.Hours()), int(age.Minutes())%60
+	if hours == 0 {
+		return fmt.Sprintf("Offline · %dm old", minutes)
 	}
+	return fmt.Sprintf("Offline · %dh %dm old", hours, minutes)
 }
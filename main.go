@@ -1,14 +1,109 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"nyct-feed/internal/config"
+	"nyct-feed/internal/gtfs"
 	"nyct-feed/internal/tui"
+	"os"
+
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 func main() {
-	m := tui.NewModel()
-	p := tea.NewProgram(&m, tea.WithAltScreen())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "record":
+			runRecord(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "departures":
+			runDepartures(os.Args[2:])
+			return
+		case "stations":
+			runStations(os.Args[2:])
+			return
+		case "routes":
+			runRoutes(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		}
+	}
+	runTUI(os.Args[1:])
+}
+
+const feedsUsage = "comma separated realtime feed sources: presets (nyct, lirr, mnr), URLs, snapshot files or directories"
+
+func runTUI(args []string) {
+	flags := flag.NewFlagSet("nyct-feed", flag.ExitOnError)
+	offline := flags.Bool("offline", false, "run from the cached schedule and recorded realtime feeds")
+	schedulePath := flags.String("schedule", "", "local GTFS schedule ZIP or directory to read instead of fetching it")
+	feeds := flags.String("feeds", "", feedsUsage+` (default "nyct", or recorded feeds when offline)`)
+	near := flags.String("near", "", "sort stations by distance from latitude,longitude, e.g. 40.73,-73.99")
+	dashboard := flags.Bool("dashboard", false, "open on the departures of every favorite station")
+	routes := flags.String("route", "", "comma separated route IDs to show at the station opened, remembered for the station")
+	direction := flags.String("direction", "", "direction to show at the station opened, N or S, remembered for the station")
+	flags.Parse(args)
+
+	var filter *gtfs.DepartureFilter
+	if *routes != "" || *direction != "" {
+		parsed := parseFilter(*routes, *direction)
+		filter = &parsed
+	}
+
+	var nearCoordinates *gtfs.Coordinates
+	if *near != "" {
+		coordinates, err := gtfs.ParseCoordinates(*near)
+		if err != nil {
+			log.Fatalln("Error parsing --near:", err)
+		}
+		nearCoordinates = &coordinates
+	}
+
+	runProgram(tui.Options{
+		FeedSources:  feedSources(*feeds, *offline),
+		Offline:      *offline,
+		SchedulePath: *schedulePath,
+		Near:         nearCoordinates,
+		Dashboard:    *dashboard,
+		Filter:       filter,
+	})
+}
+
+// feedSources parses the --feeds flag, defaulting to the recorded feeds when offline and the nyct preset otherwise.
+func feedSources(feeds string, offline bool) []gtfs.FeedSource {
+	var sources []gtfs.FeedSource
+	var err error
+	switch {
+	case feeds != "":
+		sources, err = gtfs.ParseFeedSources(feeds)
+	case offline:
+		sources, err = gtfs.RecordedFeedSources("")
+	default:
+		sources, err = gtfs.ParseFeedSources("nyct")
+	}
+	if err != nil {
+		log.Fatalln("Error configuring feeds:", err)
+	}
+	return sources
+}
+
+func runProgram(options tui.Options) {
+	cfg, err := config.Load()
+	if err != nil {
+		// Start without preferences rather than not at all; the empty config is not saved over the file
+		log.Println("Warning: ignoring config:", err)
+		cfg = &config.Config{}
+	}
+	options.Config = cfg
+
+	m := tui.NewModel(options)
+	p := tea.NewProgram(&m, tea.WithAltScreen(), tea.WithReportFocus())
 
 	f, err := tea.LogToFile("data/debug.log", "debug")
 	if err != nil {
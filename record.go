@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"nyct-feed/internal/gtfs"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// runRecord polls every realtime feed and archives new snapshots until interrupted.
+func runRecord(args []string) {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	interval := flags.Duration("interval", 15*time.Second, "how often to poll the realtime feeds")
+	dir := flags.String("dir", "", "archive directory (default data/feeds/)")
+	maxSegmentMB := flags.Int64("max-segment-mb", 64, "size in MB at which a feed's archive segment is rotated")
+	writeJson := flags.Bool("json", false, "also write each new snapshot as JSON for debugging")
+	feeds := flags.String("feeds", "nyct", feedsUsage)
+	flags.Parse(args)
+
+	sources, err := gtfs.ParseFeedSources(*feeds)
+	if err != nil {
+		log.Fatalln("Error configuring feeds:", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	archive := gtfs.NewArchive(*dir, *maxSegmentMB<<20)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		archived, err := gtfs.RecordRealtime(ctx, sources, archive, *writeJson)
+		if err != nil {
+			log.Printf("Error recording feeds: %v", err)
+		}
+		log.Printf("Archived %d new snapshots", archived)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"nyct-feed/internal/replay"
+	"nyct-feed/internal/tui"
+	"slices"
+	"time"
+)
+
+const replayTimeLayout = "2006-01-02 15:04"
+
+// runReplay plays recorded realtime feeds through the TUI against a simulated clock.
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	dir := flags.String("dir", "", "archive directory (default data/feeds/)")
+	speed := flags.Float64("speed", 1, "initial playback speed: 1, 10 or 60")
+	from := flags.String("from", "", `start of the replay window in local time, e.g. "2025-01-31 08:00"`)
+	to := flags.String("to", "", "end of the replay window in local time")
+	schedulePath := flags.String("schedule", "", "local GTFS schedule ZIP or directory (default cached schedule)")
+	flags.Parse(args)
+
+	if !slices.Contains(replay.Speeds, *speed) {
+		log.Fatalf("Invalid speed %v: must be one of %v", *speed, replay.Speeds)
+	}
+	fromTime, err := parseReplayTime(*from)
+	if err != nil {
+		log.Fatalln("Invalid --from:", err)
+	}
+	toTime, err := parseReplayTime(*to)
+	if err != nil {
+		log.Fatalln("Invalid --to:", err)
+	}
+
+	r, err := replay.Load(*dir, fromTime, toTime, *speed)
+	if err != nil {
+		log.Fatalln("Error loading replay:", err)
+	}
+
+	runProgram(tui.Options{
+		Offline:      true,
+		SchedulePath: *schedulePath,
+		Replay:       r,
+	})
+}
+
+// parseReplayTime parses a replay window bound. An empty value is the zero time.
+func parseReplayTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(replayTimeLayout, value, time.Local)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"nyct-feed/internal/server"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// runServe serves schedule and realtime data over HTTP until interrupted.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	offline := flags.Bool("offline", false, "serve the cached schedule and recorded realtime feeds")
+	schedulePath := flags.String("schedule", "", "local GTFS schedule ZIP or directory to read instead of fetching it")
+	feeds := flags.String("feeds", "", feedsUsage+` (default "nyct", or recorded feeds when offline)`)
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	s := server.New(server.Options{
+		FeedSources:  feedSources(*feeds, *offline),
+		Offline:      *offline,
+		SchedulePath: *schedulePath,
+	})
+	s.Start(ctx)
+
+	httpServer := &http.Server{Addr: *addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Listening on http://%s", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalln("Error serving:", err)
+	}
+}